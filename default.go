@@ -37,62 +37,62 @@ func Abandon(middleware interface{}) error {
 
 // Handle adds a route to the default Mux. See the documentation for web.Mux for
 // more information about what types this function accepts.
-func Handle(pattern interface{}, handler interface{}) {
-	DefaultMux.Handle(pattern, handler)
+func Handle(pattern interface{}, handler interface{}) *web.Route {
+	return DefaultMux.Handle(pattern, handler)
 }
 
 // Connect adds a CONNECT route to the default Mux. See the documentation for
 // web.Mux for more information about what types this function accepts.
-func Connect(pattern interface{}, handler interface{}) {
-	DefaultMux.Connect(pattern, handler)
+func Connect(pattern interface{}, handler interface{}) *web.Route {
+	return DefaultMux.Connect(pattern, handler)
 }
 
 // Delete adds a DELETE route to the default Mux. See the documentation for
 // web.Mux for more information about what types this function accepts.
-func Delete(pattern interface{}, handler interface{}) {
-	DefaultMux.Delete(pattern, handler)
+func Delete(pattern interface{}, handler interface{}) *web.Route {
+	return DefaultMux.Delete(pattern, handler)
 }
 
 // Get adds a GET route to the default Mux. See the documentation for web.Mux for
 // more information about what types this function accepts.
-func Get(pattern interface{}, handler interface{}) {
-	DefaultMux.Get(pattern, handler)
+func Get(pattern interface{}, handler interface{}) *web.Route {
+	return DefaultMux.Get(pattern, handler)
 }
 
 // Head adds a HEAD route to the default Mux. See the documentation for web.Mux
 // for more information about what types this function accepts.
-func Head(pattern interface{}, handler interface{}) {
-	DefaultMux.Head(pattern, handler)
+func Head(pattern interface{}, handler interface{}) *web.Route {
+	return DefaultMux.Head(pattern, handler)
 }
 
 // Options adds a OPTIONS route to the default Mux. See the documentation for
 // web.Mux for more information about what types this function accepts.
-func Options(pattern interface{}, handler interface{}) {
-	DefaultMux.Options(pattern, handler)
+func Options(pattern interface{}, handler interface{}) *web.Route {
+	return DefaultMux.Options(pattern, handler)
 }
 
 // Patch adds a PATCH route to the default Mux. See the documentation for web.Mux
 // for more information about what types this function accepts.
-func Patch(pattern interface{}, handler interface{}) {
-	DefaultMux.Patch(pattern, handler)
+func Patch(pattern interface{}, handler interface{}) *web.Route {
+	return DefaultMux.Patch(pattern, handler)
 }
 
 // Post adds a POST route to the default Mux. See the documentation for web.Mux
 // for more information about what types this function accepts.
-func Post(pattern interface{}, handler interface{}) {
-	DefaultMux.Post(pattern, handler)
+func Post(pattern interface{}, handler interface{}) *web.Route {
+	return DefaultMux.Post(pattern, handler)
 }
 
 // Put adds a PUT route to the default Mux. See the documentation for web.Mux for
 // more information about what types this function accepts.
-func Put(pattern interface{}, handler interface{}) {
-	DefaultMux.Put(pattern, handler)
+func Put(pattern interface{}, handler interface{}) *web.Route {
+	return DefaultMux.Put(pattern, handler)
 }
 
 // Trace adds a TRACE route to the default Mux. See the documentation for
 // web.Mux for more information about what types this function accepts.
-func Trace(pattern interface{}, handler interface{}) {
-	DefaultMux.Trace(pattern, handler)
+func Trace(pattern interface{}, handler interface{}) *web.Route {
+	return DefaultMux.Trace(pattern, handler)
 }
 
 // NotFound sets the NotFound handler for the default Mux. See the documentation
@@ -16,3 +16,21 @@ func (rt *router) setMachine(m *routeMachine) {
 	ptr := (*unsafe.Pointer)(unsafe.Pointer(&rt.machine))
 	atomic.StorePointer(ptr, unsafe.Pointer(m))
 }
+
+func (m *mStack) getPool() *cPool {
+	ptr := (*unsafe.Pointer)(unsafe.Pointer(&m.pool))
+	return (*cPool)(atomic.LoadPointer(ptr))
+}
+func (m *mStack) setPool(p *cPool) {
+	ptr := (*unsafe.Pointer)(unsafe.Pointer(&m.pool))
+	atomic.StorePointer(ptr, unsafe.Pointer(p))
+}
+
+func getMethods() map[string]method {
+	ptr := (*unsafe.Pointer)(unsafe.Pointer(&validMethodsMap))
+	return *(*map[string]method)(atomic.LoadPointer(ptr))
+}
+func setMethods(m map[string]method) {
+	ptr := (*unsafe.Pointer)(unsafe.Pointer(&validMethodsMap))
+	atomic.StorePointer(ptr, unsafe.Pointer(&m))
+}
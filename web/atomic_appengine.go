@@ -12,3 +12,25 @@ func (rt *router) getMachine() *routeMachine {
 func (rt *router) setMachine(m *routeMachine) {
 	rt.machine = m
 }
+
+func (m *mStack) getPool() *cPool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.pool
+}
+
+// We always hold the lock when calling setPool.
+func (m *mStack) setPool(p *cPool) {
+	m.pool = p
+}
+
+func getMethods() map[string]method {
+	methodsLock.Lock()
+	defer methodsLock.Unlock()
+	return *validMethodsMap
+}
+
+// We always hold methodsLock when calling setMethods.
+func setMethods(m map[string]method) {
+	validMethodsMap = &m
+}
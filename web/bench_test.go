@@ -5,7 +5,10 @@ import (
 	"encoding/base64"
 	mrand "math/rand"
 	"net/http"
+	"strconv"
 	"testing"
+
+	"code.google.com/p/go.net/context"
 )
 
 /*
@@ -103,7 +106,18 @@ func benchN(b *testing.B, n int) {
 }
 
 func benchM(b *testing.B, n int) {
+	benchMux(b, New(), n)
+}
+
+// benchMux pins the cost of storing the context on the requests handed to
+// net/http middleware and handlers (see Mux.RequestContext).
+func benchRequestContext(b *testing.B, n int) {
 	m := New()
+	m.RequestContext(true)
+	benchMux(b, m, n)
+}
+
+func benchMux(b *testing.B, m *Mux, n int) {
 	m.Get("/", nilRouter{})
 	for i := 0; i < n; i++ {
 		m.Use(trivialMiddleware)
@@ -151,3 +165,92 @@ func BenchmarkMiddleware10(b *testing.B) {
 func BenchmarkMiddleware100(b *testing.B) {
 	benchM(b, 100)
 }
+
+func BenchmarkRequestContext1(b *testing.B) {
+	benchRequestContext(b, 1)
+}
+func BenchmarkRequestContext10(b *testing.B) {
+	benchRequestContext(b, 10)
+}
+
+func benchTree(b *testing.B, n int) {
+	m := New()
+	m.TreeRouting(true)
+	prefixes := genPrefixes(n)
+	for _, prefix := range prefixes {
+		addRoutes(m, prefix)
+	}
+	reqs := permuteRequests(genRequests(prefixes))
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.ServeHTTP(w, reqs[i%len(reqs)])
+	}
+}
+
+func BenchmarkTreeRoute5(b *testing.B) {
+	benchTree(b, 1)
+}
+func BenchmarkTreeRoute50(b *testing.B) {
+	benchTree(b, 10)
+}
+func BenchmarkTreeRoute500(b *testing.B) {
+	benchTree(b, 100)
+}
+func BenchmarkTreeRoute5000(b *testing.B) {
+	benchTree(b, 1000)
+}
+
+// benchShared routes requests among n routes sharing a prefix, all of which the
+// bytecode must examine in turn.
+func benchShared(b *testing.B, n int, tree bool) {
+	m := New()
+	m.TreeRouting(tree)
+	reqs := make([]*http.Request, n)
+	for i := range reqs {
+		prefix := "/api/:ver/r" + strconv.Itoa(i)
+		m.Get(prefix+"/:id", nilRouter{})
+		reqs[i], _ = http.NewRequest("GET", "/api/v1/r"+strconv.Itoa(i)+"/42", nil)
+	}
+	reqs = permuteRequests(reqs)
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.ServeHTTP(w, reqs[i%len(reqs)])
+	}
+}
+
+func BenchmarkSharedPrefix2000(b *testing.B) {
+	benchShared(b, 2000, false)
+}
+func BenchmarkTreeSharedPrefix2000(b *testing.B) {
+	benchShared(b, 2000, true)
+}
+
+func benchParams(b *testing.B, h func(c context.Context, w http.ResponseWriter, r *http.Request)) {
+	m := New()
+	m.Get("/users/:user/posts/:post/comments/:comment", h)
+	r, _ := http.NewRequest("GET", "/users/carl/posts/1/comments/2", nil)
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.ServeHTTP(w, r)
+	}
+}
+
+func BenchmarkURLParam(b *testing.B) {
+	benchParams(b, func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		URLParam(c, "user")
+		URLParam(c, "comment")
+	})
+}
+func BenchmarkURLParams(b *testing.B) {
+	benchParams(b, func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		params := URLParams(c)
+		_ = params["user"]
+		_ = params["comment"]
+	})
+}
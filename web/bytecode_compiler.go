@@ -93,7 +93,7 @@ type trieSegment struct {
 	children []trie
 }
 
-func buildTrie(routes []route, dp, dr int) trie {
+func buildTrie(routes []*route, dp, dr int) trie {
 	var t trie
 	ts := trieSegment{-1, nil}
 	for i, r := range routes {
@@ -137,7 +137,7 @@ func commonPrefix(s1, s2 string) string {
 	return s1
 }
 
-func buildTrieSegment(routes []route, dp, dr int) []trie {
+func buildTrieSegment(routes []*route, dp, dr int) []trie {
 	if len(routes) == 0 {
 		return nil
 	}
@@ -250,7 +250,7 @@ func (ts trieSegment) encode(dp, off int) (me stateMachine, sub stateMachine) {
 	return
 }
 
-func compile(routes []route) stateMachine {
+func compile(routes []*route) stateMachine {
 	if len(routes) == 0 {
 		return nil
 	}
@@ -8,13 +8,21 @@ import (
 
 type routeMachine struct {
 	sm     stateMachine
-	routes []route
+	routes []*route
+	// If non-nil, the tree is used to route requests instead of the
+	// bytecode.
+	tree *routeTree
 }
 
 func (rm routeMachine) route(c context.Context, w http.ResponseWriter, r *http.Request) (methodSet, context.Context, *route) {
+	if rm.tree != nil {
+		return rm.tree.route(rm.routes, c, r)
+	}
+
 	m := httpMethod(r.Method)
 	var methods methodSet
 	p := r.URL.Path
+	rp, _ := c.(*routeParams)
 
 	if len(rm.sm) == 0 {
 		return methods, c, nil
@@ -58,15 +66,15 @@ func (rm routeMachine) route(c context.Context, w http.ResponseWriter, r *http.R
 
 		if match && sm&smRoute != 0 {
 			si := rm.sm[i].i
-			route := &rm.routes[si]
+			route := rm.routes[si]
+			mark := rp.mark()
 			if mc, ok := route.pattern.Match(r, c); ok {
 				if route.method&m != 0 {
 					return 0, mc, route
 				}
-				if m == mOPTIONS {
-					methods |= methodSet(route.method)
-				}
+				methods |= methodSet(route.method)
 			}
+			rp.truncate(mark)
 			i++
 		} else if match != (sm&smJumpOnMatch == 0) {
 			if sm&smFail != 0 {
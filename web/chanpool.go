@@ -7,25 +7,32 @@ package web
 // Maximum size of the pool of spare middleware stacks
 const cPoolSize = 32
 
-type cPool chan *cStack
+type cPool struct {
+	ch chan *cStack
+	// The middleware stack the pooled instances were built from. It must
+	// not be modified.
+	stack []interface{}
+	// Whether the pooled instances store their context on the requests
+	// they hand to net/http middleware (see Mux.RequestContext).
+	requestContext bool
+}
 
 func makeCPool() *cPool {
-	var p cPool = make(chan *cStack, cPoolSize)
-	return &p
+	return &cPool{ch: make(chan *cStack, cPoolSize)}
 }
 
-func (c cPool) alloc() *cStack {
+func (c *cPool) alloc() *cStack {
 	select {
-	case cs := <-c:
+	case cs := <-c.ch:
 		return cs
 	default:
 		return nil
 	}
 }
 
-func (c cPool) release(cs *cStack) {
+func (c *cPool) release(cs *cStack) {
 	select {
-	case c <- cs:
+	case c.ch <- cs:
 	default:
 	}
 }
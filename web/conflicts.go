@@ -0,0 +1,303 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// ConflictKind describes the way in which two or more routes conflict.
+type ConflictKind int
+
+const (
+	// Shadowed means that a route can never be reached: every request it
+	// would match is matched by an earlier route instead.
+	Shadowed ConflictKind = iota
+	// Overlap means that some, but not all, of the requests matched by a
+	// route are matched by an earlier route, and that neither route's
+	// pattern is more specific than the other's. Whether such a request
+	// reaches the intended route depends only on the order in which the
+	// routes were added.
+	Overlap
+)
+
+func (k ConflictKind) String() string {
+	switch k {
+	case Shadowed:
+		return "shadowed"
+	case Overlap:
+		return "overlap"
+	default:
+		return fmt.Sprintf("ConflictKind(%d)", int(k))
+	}
+}
+
+// RouteConflict describes a route which is shadowed or overlapped by earlier
+// routes. It is returned by Mux.Validate.
+type RouteConflict struct {
+	Kind ConflictKind
+	// Route is the route affected by the conflict.
+	Route RouteInfo
+	// Others are the earlier routes which match the requests Route was
+	// meant to match.
+	Others []RouteInfo
+	// Methods is the sorted list of HTTP methods for which the routes
+	// conflict.
+	Methods []string
+}
+
+func (c RouteConflict) String() string {
+	others := make([]string, len(c.Others))
+	for i, o := range c.Others {
+		others[i] = o.Pattern
+	}
+	return fmt.Sprintf("route %s %q: %v by %q", strings.Join(c.Methods, ","),
+		c.Route.Pattern, c.Kind, others)
+}
+
+/*
+Validate analyzes the routes registered on the mux, and reports those which
+conflict with earlier routes. Since the first matching route always wins, a
+route like "/users/:name" shadows a "/users/new" route added after it, which
+is rarely intended. Validate is meant to be called once all routes have been
+added, for instance from a test, so that such mistakes can be caught before
+they reach production.
+
+Only Sinatra-like patterns and regular expressions are analyzed, and only to the
+extent that can be done without false positives: regular expressions are
+compared by their literal prefix, and parameters with different constraints are
+assumed never to overlap. A route which is only shadowed for some of its
+methods (e.g., a route added with Handle after a Get route with the same
+pattern) is not reported, since this is the usual way of providing a fallback.
+*/
+func (m *Mux) Validate() []RouteConflict {
+	return m.rt.conflicts()
+}
+
+func (rt *router) conflicts() []RouteConflict {
+	rt.lock.Lock()
+	routes := rt.routes
+	rt.lock.Unlock()
+
+	var conflicts []RouteConflict
+	for j, rj := range routes {
+		var covered method
+		var shadows, overlaps []*route
+		for _, ri := range routes[:j] {
+			if ri.method&rj.method == 0 {
+				continue
+			}
+			switch {
+			case subsumes(ri.pattern, rj.pattern):
+				covered |= ri.method
+				shadows = append(shadows, ri)
+			case subsumes(rj.pattern, ri.pattern):
+				// The earlier route is the more specific one,
+				// which is the way routes are meant to be ordered.
+			case intersects(ri.pattern, rj.pattern):
+				overlaps = append(overlaps, ri)
+			}
+		}
+
+		if rj.method&^covered == 0 {
+			conflicts = append(conflicts, conflict(Shadowed, rj, shadows...))
+			continue
+		}
+		for _, ri := range overlaps {
+			conflicts = append(conflicts, conflict(Overlap, rj, ri))
+		}
+	}
+	return conflicts
+}
+
+func conflict(kind ConflictKind, r *route, others ...*route) RouteConflict {
+	c := RouteConflict{
+		Kind:   kind,
+		Route:  r.info(),
+		Others: make([]RouteInfo, len(others)),
+	}
+	var m method
+	for i, o := range others {
+		c.Others[i] = o.info()
+		m |= o.method
+	}
+	c.Methods = methodSet(m & r.method).names()
+	return c
+}
+
+// literalPrefix returns the prefix matched by patterns which match every path
+// starting with a literal prefix, like "/static/*".
+func literalPrefix(p Pattern) (string, bool) {
+	switch v := p.(type) {
+	case stringPattern:
+		if len(v.pats) == 0 && v.wildcard {
+			return v.literals[0], true
+		}
+	case regexpPattern:
+		re, err := syntax.Parse(v.re.String(), syntax.Perl)
+		if err != nil {
+			return "", false
+		}
+		subs := []*syntax.Regexp{re}
+		if re.Op == syntax.OpConcat {
+			subs = re.Sub
+		}
+		var buf bytes.Buffer
+		for i, sub := range subs {
+			switch {
+			case i == 0 && sub.Op == syntax.OpBeginText:
+			case sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0:
+				for _, r := range sub.Rune {
+					buf.WriteRune(r)
+				}
+			default:
+				return "", false
+			}
+		}
+		return buf.String(), true
+	}
+	return "", false
+}
+
+// segmentShape is the sequence of segments of a segment pattern (see
+// tree_router.go), along with the constraint of each parameter.
+type segmentShape struct {
+	segs        []string
+	constraints []*regexp.Regexp
+	wildcard    bool
+}
+
+func shapeOf(p Pattern) (segmentShape, bool) {
+	sp, ok := p.(stringPattern)
+	if !ok {
+		return segmentShape{}, false
+	}
+	segs, ok := segmentPattern(sp)
+	if !ok {
+		return segmentShape{}, false
+	}
+	s := segmentShape{
+		segs:        segs,
+		constraints: make([]*regexp.Regexp, len(segs)),
+		wildcard:    sp.wildcard,
+	}
+	k := 0
+	for i, seg := range segs {
+		if seg == paramSegment {
+			s.constraints[i] = sp.constraints[k]
+			k++
+		}
+	}
+	return s, true
+}
+
+// subsumes reports whether every request matched by b is also matched by a.
+func subsumes(a, b Pattern) bool {
+	if prefix, ok := literalPrefix(a); ok {
+		return strings.HasPrefix(b.Prefix(), prefix)
+	}
+	switch va := a.(type) {
+	case stringPattern:
+		if vb, ok := b.(stringPattern); ok && va.raw == vb.raw {
+			return true
+		}
+	case regexpPattern:
+		if vb, ok := b.(regexpPattern); ok && va.re.String() == vb.re.String() {
+			return true
+		}
+	}
+
+	sa, ok := shapeOf(a)
+	if !ok {
+		return false
+	}
+	sb, ok := shapeOf(b)
+	if !ok {
+		return false
+	}
+	if sa.wildcard {
+		if len(sb.segs) < len(sa.segs) ||
+			!sb.wildcard && len(sb.segs) == len(sa.segs) {
+			return false
+		}
+	} else if sb.wildcard || len(sb.segs) != len(sa.segs) {
+		return false
+	}
+
+	for i, seg := range sa.segs {
+		if seg != paramSegment {
+			if sb.segs[i] != seg {
+				return false
+			}
+			continue
+		}
+		ca, cb := sa.constraints[i], sb.constraints[i]
+		if sb.segs[i] != paramSegment {
+			if sb.segs[i] == "" || ca != nil && !ca.MatchString(sb.segs[i]) {
+				return false
+			}
+		} else if ca != nil && (cb == nil || ca.String() != cb.String()) {
+			return false
+		}
+	}
+	return true
+}
+
+// intersects reports whether some request is known to be matched by both a and
+// b. When it cannot tell, it errs on the side of reporting no intersection.
+func intersects(a, b Pattern) bool {
+	sa, ok := shapeOf(a)
+	if !ok {
+		return false
+	}
+	sb, ok := shapeOf(b)
+	if !ok {
+		return false
+	}
+	n := len(sa.segs)
+	switch {
+	case !sa.wildcard && !sb.wildcard:
+		if len(sb.segs) != n {
+			return false
+		}
+	case sa.wildcard && !sb.wildcard:
+		if len(sb.segs) <= n {
+			return false
+		}
+	case !sa.wildcard && sb.wildcard:
+		if n <= len(sb.segs) {
+			return false
+		}
+		n = len(sb.segs)
+	default:
+		if len(sb.segs) < n {
+			n = len(sb.segs)
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		segA, segB := sa.segs[i], sb.segs[i]
+		ca, cb := sa.constraints[i], sb.constraints[i]
+		switch {
+		case segA != paramSegment && segB != paramSegment:
+			if segA != segB {
+				return false
+			}
+		case segA != paramSegment:
+			if segA == "" || cb != nil && !cb.MatchString(segA) {
+				return false
+			}
+		case segB != paramSegment:
+			if segB == "" || ca != nil && !ca.MatchString(segB) {
+				return false
+			}
+		default:
+			if ca != nil && cb != nil && ca.String() != cb.String() {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -4,14 +4,22 @@ package web
 
 import "sync"
 
-type cPool sync.Pool
+type cPool struct {
+	pool sync.Pool
+	// The middleware stack the pooled instances were built from. It must
+	// not be modified.
+	stack []interface{}
+	// Whether the pooled instances store their context on the requests
+	// they hand to net/http middleware (see Mux.RequestContext).
+	requestContext bool
+}
 
 func makeCPool() *cPool {
 	return &cPool{}
 }
 
 func (c *cPool) alloc() *cStack {
-	cs := (*sync.Pool)(c).Get()
+	cs := c.pool.Get()
 	if cs == nil {
 		return nil
 	}
@@ -19,5 +27,5 @@ func (c *cPool) alloc() *cStack {
 }
 
 func (c *cPool) release(cs *cStack) {
-	(*sync.Pool)(c).Put(cs)
+	c.pool.Put(cs)
 }
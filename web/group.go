@@ -0,0 +1,172 @@
+package web
+
+import (
+	"log"
+	"net/http"
+
+	"code.google.com/p/go.net/context"
+)
+
+/*
+Group is a set of routes on a Mux which share a common path prefix and a
+middleware stack of their own. Groups are created with Mux.Group.
+
+The group's middleware stack only wraps the routes added to the group, and
+runs after the Mux's own middleware stack once the route has been selected.
+Unlike mounting a separate Mux, no additional routing takes place: routes added
+to a group are added directly to the Mux.
+
+Middleware added with Use only applies to routes added to the group afterwards.
+Nested groups inherit both the prefix and the middleware of their parent.
+*/
+type Group struct {
+	rt     *router
+	prefix string
+	ms     mStack
+}
+
+// handlerRouter allows a plain Handler to sit at the bottom of a middleware
+// stack.
+type handlerRouter struct {
+	h Handler
+}
+
+func (h handlerRouter) route(c context.Context, w http.ResponseWriter, r *http.Request) {
+	h.h.ServeHTTPC(c, w, r)
+}
+
+// matchedHandler sits at the bottom of a Mux's post-routing middleware stack,
+// and calls the handler of the route which matched the request.
+type matchedHandler struct{}
+
+func (matchedHandler) route(c context.Context, w http.ResponseWriter, r *http.Request) {
+	c.Value(routeKey).(*matchedRoute).r.handler.ServeHTTPC(c, w, r)
+}
+
+// newRouteStack returns a middleware stack made of the given layers, at the
+// bottom of which is the given handler.
+func newRouteStack(stack []interface{}, h Handler) *mStack {
+	ms := &mStack{
+		stack:  stack,
+		router: handlerRouter{h},
+	}
+	ms.invalidate()
+	return ms
+}
+
+/*
+Group creates a group of routes sharing the given prefix, and calls fn to add
+routes and middleware to it:
+
+	m.Group("/admin", func(g *web.Group) {
+		g.Use(requireAdmin)
+		g.Get("/users", listUsers)
+	})
+
+The prefix is prepended to the Sinatra-like patterns of routes added to the
+group, so that the route above matches "/admin/users". Other kinds of patterns
+can only be used in groups with an empty prefix.
+*/
+func (m *Mux) Group(prefix string, fn func(g *Group)) {
+	g := &Group{
+		rt:     &m.rt,
+		prefix: prefix,
+		ms:     mStack{stack: make([]interface{}, 0)},
+	}
+	fn(g)
+}
+
+// Group creates a nested group, whose prefix and middleware stack extend those
+// of the receiver. See Mux.Group.
+func (g *Group) Group(prefix string, fn func(g *Group)) {
+	stack := make([]interface{}, len(g.ms.stack))
+	copy(stack, g.ms.stack)
+	fn(&Group{
+		rt:     g.rt,
+		prefix: g.prefix + prefix,
+		ms:     mStack{stack: stack},
+	})
+}
+
+// Use appends the given middleware to the group's middleware stack. See the
+// documentation for type Mux for a list of valid middleware types.
+func (g *Group) Use(middleware interface{}) {
+	g.ms.Use(middleware)
+}
+
+func (g *Group) handleUntyped(pattern interface{}, m method, handler interface{}) *Route {
+	var p Pattern
+	if s, ok := pattern.(string); ok {
+		p = parseStringPattern(g.prefix + s)
+	} else if g.prefix == "" {
+		p = parsePattern(pattern)
+	} else {
+		log.Panicf("web: pattern %v cannot be added to a group with "+
+			"prefix %q: only string patterns can be prefixed",
+			pattern, g.prefix)
+	}
+
+	h := parseHandler(handler)
+	var ms *mStack
+	if len(g.ms.stack) != 0 {
+		stack := make([]interface{}, len(g.ms.stack))
+		copy(stack, g.ms.stack)
+		ms = newRouteStack(stack, h)
+	}
+	return g.rt.handle(p, m, h, handler, ms)
+}
+
+// Handle adds a route matching any HTTP method to the group. See Mux.Handle.
+func (g *Group) Handle(pattern interface{}, handler interface{}) *Route {
+	return g.handleUntyped(pattern, mALL, handler)
+}
+
+// Method adds a route for the given HTTP method to the group. See Mux.Method.
+func (g *Group) Method(method string, pattern interface{}, handler interface{}) *Route {
+	return g.handleUntyped(pattern, methodFor(method), handler)
+}
+
+// Connect adds a CONNECT route to the group. See Mux.Connect.
+func (g *Group) Connect(pattern interface{}, handler interface{}) *Route {
+	return g.handleUntyped(pattern, mCONNECT, handler)
+}
+
+// Delete adds a DELETE route to the group. See Mux.Delete.
+func (g *Group) Delete(pattern interface{}, handler interface{}) *Route {
+	return g.handleUntyped(pattern, mDELETE, handler)
+}
+
+// Get adds a GET route to the group. See Mux.Get.
+func (g *Group) Get(pattern interface{}, handler interface{}) *Route {
+	return g.handleUntyped(pattern, mGET|mHEAD, handler)
+}
+
+// Head adds a HEAD route to the group. See Mux.Head.
+func (g *Group) Head(pattern interface{}, handler interface{}) *Route {
+	return g.handleUntyped(pattern, mHEAD, handler)
+}
+
+// Options adds an OPTIONS route to the group. See Mux.Options.
+func (g *Group) Options(pattern interface{}, handler interface{}) *Route {
+	return g.handleUntyped(pattern, mOPTIONS, handler)
+}
+
+// Patch adds a PATCH route to the group. See Mux.Patch.
+func (g *Group) Patch(pattern interface{}, handler interface{}) *Route {
+	return g.handleUntyped(pattern, mPATCH, handler)
+}
+
+// Post adds a POST route to the group. See Mux.Post.
+func (g *Group) Post(pattern interface{}, handler interface{}) *Route {
+	return g.handleUntyped(pattern, mPOST, handler)
+}
+
+// Put adds a PUT route to the group. See Mux.Put.
+func (g *Group) Put(pattern interface{}, handler interface{}) *Route {
+	return g.handleUntyped(pattern, mPUT, handler)
+}
+
+// Trace adds a TRACE route to the group. See Mux.Trace.
+func (g *Group) Trace(pattern interface{}, handler interface{}) *Route {
+	return g.handleUntyped(pattern, mTRACE, handler)
+}
@@ -0,0 +1,63 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"code.google.com/p/go.net/context"
+)
+
+func TestGroup(t *testing.T) {
+	t.Parallel()
+	m := New()
+	ch := make(chan string, 10)
+
+	m.Use(chanWare(ch, "mux"))
+	m.Get("/", chHandler(ch, "root"))
+	m.Group("/admin", func(g *Group) {
+		g.Use(chanWare(ch, "admin"))
+		g.Get("/users", chHandler(ch, "users"))
+		g.Group("/:section", func(g *Group) {
+			g.Use(func(c context.Context, w http.ResponseWriter, r *http.Request, next Handler) {
+				ch <- "section " + URLParams(c)["section"]
+				next.ServeHTTPC(c, w, r)
+			})
+			g.Post("/", chHandler(ch, "post"))
+		})
+	})
+
+	for _, test := range []struct {
+		method, path string
+		order        []string
+	}{
+		{"GET", "/", []string{"mux", "root", "end"}},
+		{"GET", "/admin/users", []string{"mux", "admin", "users", "end"}},
+		{"POST", "/admin/news/", []string{"mux", "admin", "section news",
+			"post", "end"}},
+	} {
+		r, _ := http.NewRequest(test.method, test.path, nil)
+		m.ServeHTTP(httptest.NewRecorder(), r)
+		ch <- "end"
+		assertOrder(t, ch, test.order...)
+	}
+}
+
+func TestGroupPatterns(t *testing.T) {
+	t.Parallel()
+	m := New()
+
+	m.Group("", func(g *Group) {
+		g.Get(regexp.MustCompile(`^/hello$`), http.NotFound)
+	})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic when prefixing a regexp")
+		}
+	}()
+	m.Group("/prefix", func(g *Group) {
+		g.Get(regexp.MustCompile(`^/hello$`), http.NotFound)
+	})
+}
@@ -0,0 +1,103 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"code.google.com/p/go.net/context"
+)
+
+// hostPattern is a Pattern that matches a request's Host before delegating to
+// another Pattern for its path.
+type hostPattern struct {
+	raw    string
+	labels []string
+	path   Pattern
+}
+
+/*
+Host returns a Pattern which matches requests whose Host matches the given host
+pattern and whose path matches the given path pattern. The path pattern may be
+of any of the types accepted by the route-adding functions on Mux (see the
+documentation for type Mux).
+
+The host pattern is a dot-separated list of labels, which are matched against
+the labels of the request's Host (ignoring any port) without regard to case. A
+label starting with a colon matches any label, binding its value in the same
+way Sinatra-like path patterns do. A label consisting only of "*" matches any
+label without binding it. For instance,
+
+	m.Get(web.Host(":tenant.example.com", "/users/:name"), handler)
+
+will match a request for "http://acme.example.com/users/carl", binding
+"tenant" to "acme" and "name" to "carl", but will not match a request for
+"http://example.com/users/carl".
+*/
+func Host(host string, path interface{}) Pattern {
+	return hostPattern{
+		raw:    host,
+		labels: strings.Split(host, "."),
+		path:   parsePattern(path),
+	}
+}
+
+func (h hostPattern) Prefix() string {
+	return h.path.Prefix()
+}
+
+func (h hostPattern) Match(r *http.Request, c context.Context) (context.Context, bool) {
+	host := r.Host
+	if i := strings.LastIndex(host, ":"); i != -1 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	labels := strings.Split(host, ".")
+	if len(labels) != len(h.labels) {
+		return c, false
+	}
+
+	var matches map[string]string
+	for i, label := range h.labels {
+		switch {
+		case label == "*":
+			if labels[i] == "" {
+				return c, false
+			}
+		case strings.HasPrefix(label, ":") && len(label) > 1:
+			if labels[i] == "" {
+				return c, false
+			}
+			if matches == nil {
+				matches = make(map[string]string)
+			}
+			matches[label[1:]] = labels[i]
+		default:
+			if !strings.EqualFold(label, labels[i]) {
+				return c, false
+			}
+		}
+	}
+
+	pc, ok := h.path.Match(r, c)
+	if !ok || matches == nil {
+		return pc, ok
+	}
+	for k, v := range URLParams(pc) {
+		matches[k] = v
+	}
+	return withURLParams(c, matches), true
+}
+
+// buildPath only builds the path, since that is all Mux.URL promises.
+func (h hostPattern) buildPath(params map[string]string) (string, error) {
+	b, ok := h.path.(urlBuilder)
+	if !ok {
+		return "", fmt.Errorf("path pattern of type %T cannot be "+
+			"reversed", h.path)
+	}
+	return b.buildPath(params)
+}
+
+func (h hostPattern) String() string {
+	return h.raw + patternString(h.path)
+}
@@ -12,11 +12,18 @@ import (
 // mStack is an entire middleware stack. It contains a slice of middleware
 // layers (outermost first) protected by a mutex, a cache of pre-built stack
 // instances, and a final routing function.
+//
+// The stack is copied on write: a slice of layers is never modified once it
+// has been stored in the stack. Each cache (which is swapped atomically)
+// remembers which slice its instances were built from, which allows the stack
+// to be modified while requests are being served. In-flight requests simply
+// finish with the instance they started with.
 type mStack struct {
-	lock   sync.Mutex
-	stack  []interface{}
-	pool   *cPool
-	router internalRouter
+	lock           sync.Mutex
+	stack          []interface{}
+	requestContext bool
+	pool           *cPool
+	router         internalRouter
 }
 
 type internalRouter interface {
@@ -34,6 +41,13 @@ type cStack struct {
 	ctx  context.Context
 	m    Handler
 	pool *cPool
+	// The context requests are routed in, which holds their URL
+	// parameters.
+	params *routeParams
+	// Whether the context is stored on the requests handed to net/http
+	// middleware, and whether the request handed to the current one
+	// carries ctx.
+	requestContext, carried bool
 }
 
 func (s *cStack) ServeHTTPC(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -42,18 +56,23 @@ func (s *cStack) ServeHTTPC(ctx context.Context, w http.ResponseWriter, r *http.
 
 func (s *cStack) toHTTPHandler(h HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		h(s.ctx, w, r)
+		c := s.ctx
+		if s.carried {
+			c = contextOf(r, c)
+		}
+		h(c, w, r)
 	})
 }
 
 func (s *cStack) fromHTTPHandler(h http.HandlerFunc) HandlerFunc {
 	return HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		s.ctx = ctx
+		r, s.carried = withContext(ctx, r, s.requestContext)
 		h(w, r)
 	})
 }
 
-func (m *mStack) appendLayer(fn interface{}) {
+func checkLayer(fn interface{}) {
 	switch fn.(type) {
 	case func(http.Handler) http.Handler:
 	case func(Handler) Handler:
@@ -64,7 +83,6 @@ func (m *mStack) appendLayer(fn interface{}) {
 			`"func(web.Handler) web.Handler" or `+
 			`"func(context.Context, http.ResponseWriter, *http.Request, Handler".`, fn)
 	}
-	m.stack = append(m.stack, fn)
 }
 
 func (m *mStack) findLayer(l interface{}) int {
@@ -77,7 +95,10 @@ func (m *mStack) findLayer(l interface{}) int {
 }
 
 func (m *mStack) invalidate() {
-	m.pool = makeCPool()
+	p := makeCPool()
+	p.stack = m.stack
+	p.requestContext = m.requestContext
+	m.setPool(p)
 }
 
 type handlerNext struct {
@@ -89,14 +110,27 @@ func (h handlerNext) apply(c context.Context, w http.ResponseWriter, r *http.Req
 	h.f(c, w, r, h.next)
 }
 
-func (m *mStack) newStack() *cStack {
-	cs := cStack{}
+func (m *mStack) newStack(p *cPool) *cStack {
+	stack := p.stack
+	cs := cStack{
+		params:         &routeParams{requestContext: p.requestContext},
+		requestContext: p.requestContext,
+	}
+	// Only Muxes route requests to patterns: the routers of groups simply
+	// call their handler.
+	_, routes := m.router.(*router)
 	router := m.router
 
 	h := HandlerFunc(router.route)
+	if routes {
+		h = func(c context.Context, w http.ResponseWriter, r *http.Request) {
+			cs.params.reset(c)
+			router.route(cs.params, w, r)
+		}
+	}
 
-	for i := len(m.stack) - 1; i >= 0; i-- {
-		switch fn := m.stack[i].(type) {
+	for i := len(stack) - 1; i >= 0; i-- {
+		switch fn := stack[i].(type) {
 		case func(http.Handler) http.Handler:
 			httphandler := cs.toHTTPHandler(h)
 			h = cs.fromHTTPHandler(fn(httphandler).ServeHTTP)
@@ -111,10 +145,10 @@ func (m *mStack) newStack() *cStack {
 }
 
 func (m *mStack) alloc() *cStack {
-	p := m.pool
+	p := m.getPool()
 	cs := p.alloc()
 	if cs == nil {
-		cs = m.newStack()
+		cs = m.newStack(p)
 	}
 
 	cs.pool = p
@@ -123,21 +157,40 @@ func (m *mStack) alloc() *cStack {
 
 func (m *mStack) release(cs *cStack) {
 	cs.ctx = nil
-	if cs.pool != m.pool {
+	if cs.params.escaped {
+		cs.params = &routeParams{requestContext: cs.requestContext}
+	} else {
+		cs.params.reset(nil)
+	}
+	if cs.pool != m.getPool() {
 		return
 	}
 	cs.pool.release(cs)
 	cs.pool = nil
 }
 
+// ServeHTTPC runs the request through a pooled instance of the stack.
+func (m *mStack) ServeHTTPC(c context.Context, w http.ResponseWriter, r *http.Request) {
+	cs := m.alloc()
+	cs.ServeHTTPC(c, w, r)
+	m.release(cs)
+}
+
 func (m *mStack) Use(middleware interface{}) {
+	checkLayer(middleware)
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	m.appendLayer(middleware)
+
+	stack := make([]interface{}, len(m.stack)+1)
+	copy(stack, m.stack)
+	stack[len(m.stack)] = middleware
+
+	m.stack = stack
 	m.invalidate()
 }
 
 func (m *mStack) Insert(middleware, before interface{}) error {
+	checkLayer(middleware)
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	i := m.findLayer(before)
@@ -145,11 +198,12 @@ func (m *mStack) Insert(middleware, before interface{}) error {
 		return fmt.Errorf("web: unknown middleware %v", before)
 	}
 
-	m.appendLayer(middleware)
-	inserted := m.stack[len(m.stack)-1]
-	copy(m.stack[i+1:], m.stack[i:])
-	m.stack[i] = inserted
+	stack := make([]interface{}, len(m.stack)+1)
+	copy(stack, m.stack[:i])
+	stack[i] = middleware
+	copy(stack[i+1:], m.stack[i:])
 
+	m.stack = stack
 	m.invalidate()
 	return nil
 }
@@ -162,9 +216,11 @@ func (m *mStack) Abandon(middleware interface{}) error {
 		return fmt.Errorf("web: unknown middleware %v", middleware)
 	}
 
-	copy(m.stack[i:], m.stack[i+1:])
-	m.stack = m.stack[:len(m.stack)-1 : len(m.stack)]
+	stack := make([]interface{}, len(m.stack)-1)
+	copy(stack, m.stack[:i])
+	copy(stack[i:], m.stack[i+1:])
 
+	m.stack = stack
 	m.invalidate()
 	return nil
 }
@@ -0,0 +1,11 @@
+// +build go1.8
+
+package middleware
+
+import "net/http"
+
+// abortsHandler reports whether a handler panicked with http.ErrAbortHandler,
+// which net/http recovers from silently.
+func abortsHandler(err interface{}) bool {
+	return err == http.ErrAbortHandler
+}
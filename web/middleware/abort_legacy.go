@@ -0,0 +1,9 @@
+// +build !go1.8
+
+package middleware
+
+// Before Go 1.8, there is no http.ErrAbortHandler: see abort.go.
+
+func abortsHandler(err interface{}) bool {
+	return false
+}
@@ -0,0 +1,37 @@
+// +build go1.8
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/vanackere/slim/web"
+)
+
+func TestRecovererAbort(t *testing.T) {
+	reported := false
+	m := web.New()
+	m.Use(NewRecoverer(RecovererOptions{
+		Reporter: PanicReporterFunc(func(ctx context.Context, r *http.Request, p *Panic) {
+			reported = true
+		}),
+	}))
+	m.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("Expected http.ErrAbortHandler to be passed on, got %v", err)
+		}
+		if reported {
+			t.Error("Expected http.ErrAbortHandler not to be reported")
+		}
+	}()
+	r, _ := http.NewRequest("GET", "/", nil)
+	m.ServeHTTP(httptest.NewRecorder(), r)
+}
@@ -0,0 +1,270 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/vanackere/slim/web"
+	"github.com/vanackere/slim/web/util"
+)
+
+// Formats for AccessLog, producing the Common Log Format and the Combined Log
+// Format of the NCSA and Apache web servers, which most log analyzers read.
+const (
+	CommonLogFormat   = `%h %l %u %t "%r" %>s %b`
+	CombinedLogFormat = CommonLogFormat + ` "%{Referer}i" "%{User-Agent}i"`
+)
+
+// accessRecord holds what the directives of an access log format may refer to.
+type accessRecord struct {
+	r       *http.Request
+	w       util.WriterProxy
+	start   time.Time
+	latency time.Duration
+	path    string
+	rawQ    string
+}
+
+type accessDirective func(buf *bytes.Buffer, a *accessRecord)
+
+/*
+AccessLog returns a middleware that writes a line describing each request to w,
+once the request has been served. Lines are formatted according to format, in
+which the following directives of Apache's LogFormat are replaced by the
+corresponding properties of the request:
+
+	%%          a literal "%"
+	%a, %h      the IP address of the client (see RealIP)
+	%l          always "-" (the remote logname)
+	%u          the user name given with HTTP Basic authentication, or "-"
+	%t          the time at which the request started, in brackets
+	%r          the request line, as in "GET /index.html HTTP/1.1"
+	%m, %U, %q  the method, path and query string (with its "?") of the request
+	%H          the protocol of the request
+	%s, %>s     the status of the response
+	%b          the size of the response body in bytes, or "-" if it is empty
+	%B          the size of the response body in bytes
+	%D, %T      the time taken to serve the request, in microseconds and seconds
+	%{Name}i    the value of the request header Name, or "-"
+	%{Name}o    the value of the response header Name, or "-"
+
+Quotes, backslashes and control characters in the values of %r, %u and headers
+are escaped as Apache does, so that CommonLogFormat and CombinedLogFormat
+produce lines which can be parsed unambiguously. AccessLog panics if format
+contains any other directive.
+
+Each line is written with a single call to w's Write method, and concurrent
+requests never interleave their lines, which makes it safe to use with writers
+which reopen or replace their files on rotation. See LogFile for a writer which
+buffers the lines and can be reopened.
+*/
+func AccessLog(format string, w io.Writer) func(context.Context, http.ResponseWriter, *http.Request, web.Handler) {
+	directives := parseLogFormat(format)
+	var lock sync.Mutex
+	var buf bytes.Buffer
+
+	return func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next web.Handler) {
+		a := accessRecord{
+			r:     r,
+			w:     util.WrapWriter(rw),
+			start: time.Now(),
+			path:  r.URL.Path,
+			rawQ:  r.URL.RawQuery,
+		}
+		next.ServeHTTPC(ctx, a.w, r)
+		a.latency = time.Since(a.start)
+
+		lock.Lock()
+		defer lock.Unlock()
+		buf.Reset()
+		for _, d := range directives {
+			d(&buf, &a)
+		}
+		buf.WriteByte('\n')
+		w.Write(buf.Bytes())
+	}
+}
+
+func parseLogFormat(format string) []accessDirective {
+	var directives []accessDirective
+	for {
+		i := strings.IndexByte(format, '%')
+		if i == -1 {
+			break
+		}
+		if i > 0 {
+			directives = append(directives, literalDirective(format[:i]))
+		}
+		format = format[i+1:]
+
+		var arg string
+		if strings.HasPrefix(format, "{") {
+			j := strings.IndexByte(format, '}')
+			if j == -1 {
+				log.Panicf("middleware: unterminated %%{ in access log format")
+			}
+			arg, format = format[1:j], format[j+1:]
+		}
+		format = strings.TrimPrefix(format, ">")
+		if format == "" {
+			log.Panicf("middleware: access log format ends with %%")
+		}
+
+		d, ok := accessDirectives[format[0]]
+		if arg != "" {
+			switch format[0] {
+			case 'i':
+				d, ok = requestHeaderDirective(arg), true
+			case 'o':
+				d, ok = responseHeaderDirective(arg), true
+			default:
+				ok = false
+			}
+		}
+		if !ok {
+			log.Panicf("middleware: unknown directive %%%c in access "+
+				"log format", format[0])
+		}
+		directives = append(directives, d)
+		format = format[1:]
+	}
+	if format != "" {
+		directives = append(directives, literalDirective(format))
+	}
+	return directives
+}
+
+var accessDirectives = map[byte]accessDirective{
+	'%': literalDirective("%"),
+	'a': remoteHost,
+	'h': remoteHost,
+	'l': literalDirective("-"),
+	'u': func(buf *bytes.Buffer, a *accessRecord) {
+		user, _, ok := a.r.BasicAuth()
+		if !ok || user == "" {
+			buf.WriteByte('-')
+			return
+		}
+		writeEscaped(buf, user)
+	},
+	't': func(buf *bytes.Buffer, a *accessRecord) {
+		buf.WriteString(a.start.Format("[02/Jan/2006:15:04:05 -0700]"))
+	},
+	'r': func(buf *bytes.Buffer, a *accessRecord) {
+		writeEscaped(buf, a.r.Method)
+		buf.WriteByte(' ')
+		uri := a.r.RequestURI
+		if uri == "" {
+			uri = a.r.URL.RequestURI()
+		}
+		writeEscaped(buf, uri)
+		buf.WriteByte(' ')
+		writeEscaped(buf, a.r.Proto)
+	},
+	'm': func(buf *bytes.Buffer, a *accessRecord) {
+		writeEscaped(buf, a.r.Method)
+	},
+	'U': func(buf *bytes.Buffer, a *accessRecord) {
+		writeEscaped(buf, a.path)
+	},
+	'q': func(buf *bytes.Buffer, a *accessRecord) {
+		if a.rawQ != "" {
+			buf.WriteByte('?')
+			writeEscaped(buf, a.rawQ)
+		}
+	},
+	'H': func(buf *bytes.Buffer, a *accessRecord) {
+		writeEscaped(buf, a.r.Proto)
+	},
+	's': func(buf *bytes.Buffer, a *accessRecord) {
+		status := a.w.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		buf.WriteString(strconv.Itoa(status))
+	},
+	'b': func(buf *bytes.Buffer, a *accessRecord) {
+		if n := a.w.BytesWritten(); n != 0 {
+			buf.WriteString(strconv.Itoa(n))
+		} else {
+			buf.WriteByte('-')
+		}
+	},
+	'B': func(buf *bytes.Buffer, a *accessRecord) {
+		buf.WriteString(strconv.Itoa(a.w.BytesWritten()))
+	},
+	'D': func(buf *bytes.Buffer, a *accessRecord) {
+		buf.WriteString(strconv.FormatInt(int64(a.latency/time.Microsecond), 10))
+	},
+	'T': func(buf *bytes.Buffer, a *accessRecord) {
+		buf.WriteString(strconv.FormatInt(int64(a.latency/time.Second), 10))
+	},
+}
+
+func literalDirective(s string) accessDirective {
+	return func(buf *bytes.Buffer, a *accessRecord) {
+		buf.WriteString(s)
+	}
+}
+
+func remoteHost(buf *bytes.Buffer, a *accessRecord) {
+	host, _, err := net.SplitHostPort(a.r.RemoteAddr)
+	if err != nil {
+		host = a.r.RemoteAddr
+	}
+	if host == "" {
+		host = "-"
+	}
+	writeEscaped(buf, host)
+}
+
+func requestHeaderDirective(name string) accessDirective {
+	name = http.CanonicalHeaderKey(name)
+	return func(buf *bytes.Buffer, a *accessRecord) {
+		writeHeader(buf, a.r.Header, name)
+	}
+}
+
+func responseHeaderDirective(name string) accessDirective {
+	name = http.CanonicalHeaderKey(name)
+	return func(buf *bytes.Buffer, a *accessRecord) {
+		writeHeader(buf, a.w.Header(), name)
+	}
+}
+
+func writeHeader(buf *bytes.Buffer, h http.Header, name string) {
+	if v := h.Get(name); v != "" {
+		writeEscaped(buf, v)
+	} else {
+		buf.WriteByte('-')
+	}
+}
+
+// writeEscaped writes s to buf, escaping quotes, backslashes and non-printable
+// bytes the way Apache does.
+func writeEscaped(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c < ' ' || c >= 0x7f:
+			buf.WriteString(`\x`)
+			buf.WriteByte(hex[c>>4])
+			buf.WriteByte(hex[c&0xf])
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/vanackere/slim/web"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	m := web.New()
+	m.Use(AccessLog(CombinedLogFormat+" %D%%", &buf))
+	m.Get("/hello", func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	r, _ := http.NewRequest("GET", "/hello?x=1", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.SetBasicAuth("carl", "secret")
+	r.Header.Set("User-Agent", `curl "7"`)
+	m.ServeHTTP(httptest.NewRecorder(), r)
+
+	line := buf.String()
+	// Skip the time, which is between brackets.
+	i, j := bytes.IndexByte(buf.Bytes(), '['), bytes.IndexByte(buf.Bytes(), ']')
+	if i == -1 || j < i {
+		t.Fatalf("No time in %q", line)
+	}
+	expected := `10.0.0.1 - carl  "GET /hello?x=1 HTTP/1.1" 200 5 "-" "curl \"7\"" `
+	actual := line[:i] + strings.TrimRight(line[j+1:], "0123456789%\n")
+	if actual != expected {
+		t.Errorf("Expected %q, got %q", expected, actual)
+	}
+
+	buf.Reset()
+	r, _ = http.NewRequest("GET", "/nope", nil)
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	if !bytes.Contains(buf.Bytes(), []byte(`"GET /nope HTTP/1.1" 404 19 `)) {
+		t.Errorf("Unexpected line for a 404: %q", buf.String())
+	}
+}
+
+func TestAccessLogFormatPanics(t *testing.T) {
+	for _, format := range []string{"%z", "%{Referer}s", "%{Referer", "%h %"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a panic for %q", format)
+				}
+			}()
+			AccessLog(format, &bytes.Buffer{})
+		}()
+	}
+}
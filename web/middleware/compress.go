@@ -0,0 +1,395 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/vanackere/slim/web"
+)
+
+// Responses whose body is shorter than this are not worth compressing.
+const compressMinSize = 1024
+
+// Media types whose content is already compressed, in addition to every
+// "image/", "audio/" and "video/" type but those of compressibleMedia.
+var incompressibleTypes = map[string]bool{
+	"application/gzip":             true,
+	"application/pdf":              true,
+	"application/x-7z-compressed":  true,
+	"application/x-bzip2":          true,
+	"application/x-gzip":           true,
+	"application/x-rar-compressed": true,
+	"application/zip":              true,
+	"font/woff":                    true,
+	"font/woff2":                   true,
+	"application/font-woff":        true,
+}
+
+var compressibleMedia = map[string]bool{
+	"image/svg+xml": true,
+	"image/x-icon":  true,
+	"image/bmp":     true,
+}
+
+// Compressor is a writer which compresses what is written to it, and which can
+// be reused with Reset, as *gzip.Writer and *zlib.Writer can. See
+// RegisterEncoding.
+type Compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// An encoding registered with RegisterEncoding.
+type encoding struct {
+	name string
+	new  func() Compressor
+}
+
+var encodingsLock sync.Mutex
+
+// The registered encodings, in order of registration.
+var encodings []encoding
+
+/*
+RegisterEncoding makes the content coding of the given name (as it appears in
+Accept-Encoding headers) available to the middleware subsequently returned by
+Compress, which calls newCompressor whenever it needs a new Compressor for it.
+For instance, Brotli, for which Go's standard library has no encoder, can be
+added with a third-party package:
+
+	middleware.RegisterEncoding("br", func() middleware.Compressor {
+		return brotli.NewWriterLevel(nil, 5)
+	})
+
+Registered encodings are preferred to gzip and deflate when the client accepts
+them equally, and the last one registered to the others. Registering an encoding
+again (including "gzip" or "deflate") replaces it.
+*/
+func RegisterEncoding(name string, newCompressor func() Compressor) {
+	name = strings.ToLower(name)
+	encodingsLock.Lock()
+	defer encodingsLock.Unlock()
+
+	for i, e := range encodings {
+		if e.name == name {
+			encodings = append(encodings[:i:i], encodings[i+1:]...)
+			break
+		}
+	}
+	encodings = append(encodings, encoding{name, newCompressor})
+}
+
+/*
+Compress returns a middleware that compresses response bodies with gzip or
+deflate (the zlib format of RFC 1950, as HTTP defines it), at the given level of
+compress/flate, or with any encoding registered with RegisterEncoding, according
+to the Accept-Encoding header of the request.
+
+Bodies are only compressed if they are at least 1024 bytes long, if they do not
+already have a Content-Encoding, and if their Content-Type (sniffed with
+http.DetectContentType if the handler did not set one) is not a compressed
+format, such as JPEG images or zip archives. Partial content responses are
+never compressed. A "Vary: Accept-Encoding" header is added to every response.
+
+Handlers may flush (see http.Flusher) and hijack (see http.Hijacker) the
+connection as usual. Flushing a response which has not been compressed yet
+commits to compressing it, whatever the length of its body. Compressors are
+pooled, so that serving a request does not require allocating a new one.
+
+Compress panics if level is not a valid compression level.
+*/
+func Compress(level int) func(context.Context, http.ResponseWriter, *http.Request, web.Handler) {
+	if _, err := gzip.NewWriterLevel(nil, level); err != nil {
+		log.Panicf("middleware: invalid compression level %d", level)
+	}
+	pools := map[string]*sync.Pool{
+		"gzip": {New: func() interface{} {
+			cw, _ := gzip.NewWriterLevel(nil, level)
+			return cw
+		}},
+		"deflate": {New: func() interface{} {
+			cw, _ := zlib.NewWriterLevel(nil, level)
+			return cw
+		}},
+	}
+	// Ties go to gzip, which clients handle more consistently than
+	// deflate.
+	prefs := []string{"gzip", "deflate"}
+
+	encodingsLock.Lock()
+	for _, e := range encodings {
+		newCompressor := e.new
+		pools[e.name] = &sync.Pool{New: func() interface{} {
+			return newCompressor()
+		}}
+		if e.name != "gzip" && e.name != "deflate" {
+			prefs = append([]string{e.name}, prefs...)
+		}
+	}
+	encodingsLock.Unlock()
+
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request, next web.Handler) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc := negotiateEncoding(r.Header.Get("Accept-Encoding"), prefs)
+		if enc == "" {
+			next.ServeHTTPC(ctx, w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, enc: enc, pool: pools[enc]}
+		// Even if the handler panics, what it wrote must be a complete
+		// stream.
+		defer cw.close()
+		next.ServeHTTPC(ctx, wrapCompressWriter(cw), r)
+	}
+}
+
+// negotiateEncoding returns the encoding of prefs which has the highest quality
+// in the given Accept-Encoding header, or the empty string if there is none.
+// Ties go to the encoding which comes first in prefs.
+func negotiateEncoding(header string, prefs []string) string {
+	// The quality of "*" applies to the encodings which are not listed,
+	// if any.
+	qs := make(map[string]float64)
+	starQ := 0.0
+	for _, s := range strings.Split(header, ",") {
+		coding, q := s, 1.0
+		if i := strings.IndexByte(s, ';'); i != -1 {
+			coding = s[:i]
+			param := strings.TrimSpace(s[i+1:])
+			if strings.HasPrefix(param, "q=") {
+				var err error
+				if q, err = strconv.ParseFloat(param[2:], 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "x-gzip" {
+			coding = "gzip"
+		}
+		if coding == "*" {
+			starQ = q
+		} else if coding != "" {
+			qs[coding] = q
+		}
+	}
+
+	var best string
+	var bestQ float64
+	for _, enc := range prefs {
+		q, ok := qs[enc]
+		if !ok {
+			q = starQ
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+func compressibleType(contentType string) bool {
+	mt := contentType
+	if i := strings.IndexByte(mt, ';'); i != -1 {
+		mt = mt[:i]
+	}
+	mt = strings.ToLower(strings.TrimSpace(mt))
+	if compressibleMedia[mt] {
+		return true
+	}
+	if incompressibleTypes[mt] || strings.HasPrefix(mt, "image/") ||
+		strings.HasPrefix(mt, "audio/") || strings.HasPrefix(mt, "video/") {
+		return false
+	}
+	return true
+}
+
+// compressWriter buffers the beginning of the response until it can decide
+// whether to compress it, and then either compresses the rest of it or passes
+// it through.
+type compressWriter struct {
+	http.ResponseWriter
+	enc  string
+	pool *sync.Pool
+	// The compressor, once we have decided to compress the response.
+	cw      Compressor
+	buf     []byte
+	code    int
+	decided bool
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	// Informational responses (such as 103 Early Hints) precede the actual
+	// response, and leave it undecided.
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.decided || w.code != 0 {
+		return
+	}
+	w.code = code
+	h := w.Header()
+	if code < 200 || code == http.StatusNoContent ||
+		code == http.StatusNotModified || code == http.StatusPartialContent ||
+		h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		w.decide(false)
+	} else if cl, err := strconv.Atoi(h.Get("Content-Length")); err == nil && cl < compressMinSize {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < compressMinSize {
+			return len(p), nil
+		}
+		return len(p), w.decide(true)
+	}
+	if w.cw != nil {
+		return w.cw.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// decide writes the header of the response, compressed if compress is true and
+// its Content-Type allows it, and whatever part of its body has been buffered.
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	h := w.Header()
+	if compress && len(w.buf) != 0 && h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if compress && h.Get("Content-Encoding") == "" &&
+		compressibleType(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.enc)
+		w.cw = w.pool.Get().(Compressor)
+		w.cw.Reset(w.ResponseWriter)
+	}
+	if w.code != 0 {
+		w.ResponseWriter.WriteHeader(w.code)
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.cw != nil {
+		_, err := w.cw.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+func (w *compressWriter) close() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.cw != nil {
+		w.cw.Close()
+		w.cw.Reset(nil)
+		w.pool.Put(w.cw)
+		w.cw = nil
+	}
+}
+
+func (w *compressWriter) flush() {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.cw != nil {
+		w.cw.Flush()
+	}
+}
+
+// wrapCompressWriter returns a ResponseWriter which exposes the optional
+// interfaces of the underlying ResponseWriter, like util.WrapWriter does.
+func wrapCompressWriter(w *compressWriter) http.ResponseWriter {
+	_, cn := w.ResponseWriter.(http.CloseNotifier)
+	_, fl := w.ResponseWriter.(http.Flusher)
+	_, hj := w.ResponseWriter.(http.Hijacker)
+	_, rf := w.ResponseWriter.(io.ReaderFrom)
+	if cn && fl && hj && rf {
+		return &fancyCompressWriter{w}
+	}
+	if cn && fl {
+		return &notifyFlushCompressWriter{flushCompressWriter{w}}
+	}
+	if fl {
+		return &flushCompressWriter{w}
+	}
+	return w
+}
+
+// flushCompressWriter is a compressWriter which additionally satisfies
+// http.Flusher.
+type flushCompressWriter struct {
+	*compressWriter
+}
+
+func (f *flushCompressWriter) Flush() {
+	f.flush()
+	f.ResponseWriter.(http.Flusher).Flush()
+}
+
+// notifyFlushCompressWriter is a flushCompressWriter which additionally
+// satisfies http.CloseNotifier, for the ResponseWriters of HTTP/2 connections,
+// which cannot be hijacked.
+type notifyFlushCompressWriter struct {
+	flushCompressWriter
+}
+
+func (f *notifyFlushCompressWriter) CloseNotify() <-chan bool {
+	return f.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+// fancyCompressWriter is a compressWriter which additionally satisfies
+// http.CloseNotifier, http.Flusher, http.Hijacker, and io.ReaderFrom, for the
+// common case of wrapping the http.ResponseWriter that package http gives us.
+type fancyCompressWriter struct {
+	*compressWriter
+}
+
+func (f *fancyCompressWriter) CloseNotify() <-chan bool {
+	return f.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+func (f *fancyCompressWriter) Flush() {
+	f.flush()
+	f.ResponseWriter.(http.Flusher).Flush()
+}
+func (f *fancyCompressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return f.ResponseWriter.(http.Hijacker).Hijack()
+}
+func (f *fancyCompressWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(f.compressWriter, r)
+}
+
+var _ http.CloseNotifier = &notifyFlushCompressWriter{}
+var _ http.Flusher = &notifyFlushCompressWriter{}
+
+var _ http.CloseNotifier = &fancyCompressWriter{}
+var _ http.Flusher = &fancyCompressWriter{}
+var _ http.Hijacker = &fancyCompressWriter{}
+var _ io.ReaderFrom = &fancyCompressWriter{}
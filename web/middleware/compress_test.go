@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vanackere/slim/web"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for header, expected := range map[string]string{
+		"":                           "",
+		"identity":                   "",
+		"gzip":                       "gzip",
+		"deflate, gzip":              "gzip",
+		"gzip;q=0.5, deflate":        "deflate",
+		"gzip;q=0, deflate;q=0":      "",
+		"br, *":                      "gzip",
+		"gzip;q=0, *":                "deflate",
+		"*, gzip;q=0, deflate;q=0":   "",
+		"GZIP;q=0.8, deflate;q=0.2 ": "gzip",
+	} {
+		if actual := negotiateEncoding(header, []string{"gzip", "deflate"}); actual != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, header, actual)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	long := strings.Repeat("hello, world ", 100)
+	m := web.New()
+	m.Use(Compress(gzip.DefaultCompression))
+	m.Get("/long", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, long[:10])
+		io.WriteString(w, long[10:])
+	})
+	m.Get("/short", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	})
+	m.Get("/png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, long)
+	})
+	m.Get("/flush", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+		w.(http.Flusher).Flush()
+	})
+
+	for _, test := range []struct {
+		path, accept, encoding, body string
+	}{
+		{"/long", "gzip", "gzip", long},
+		{"/long", "deflate", "deflate", long},
+		{"/long", "", "", long},
+		{"/short", "gzip", "", "hello"},
+		{"/png", "gzip", "", long},
+		{"/flush", "gzip", "gzip", "hello"},
+	} {
+		r, _ := http.NewRequest("GET", test.path, nil)
+		r.Header.Set("Accept-Encoding", test.accept)
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+
+		h := w.Result().Header
+		if enc := h.Get("Content-Encoding"); enc != test.encoding {
+			t.Errorf("Expected encoding %q for %s (%s), got %q",
+				test.encoding, test.path, test.accept, enc)
+			continue
+		}
+		if v := h.Get("Vary"); v != "Accept-Encoding" {
+			t.Errorf("Expected Vary: Accept-Encoding for %s, got %q",
+				test.path, v)
+		}
+		var body io.Reader = w.Body
+		switch test.encoding {
+		case "gzip":
+			body, _ = gzip.NewReader(body)
+		case "deflate":
+			body, _ = zlib.NewReader(body)
+		}
+		if b, err := ioutil.ReadAll(body); err != nil || !bytes.Equal(b, []byte(test.body)) {
+			t.Errorf("Unexpected body for %s (%s): %q (%v)", test.path,
+				test.accept, b, err)
+		}
+	}
+}
+
+func TestCompressPanic(t *testing.T) {
+	long := strings.Repeat("hello, world ", 100)
+	m := web.New()
+	m.Use(Recoverer)
+	m.Use(Compress(gzip.DefaultCompression))
+	m.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, long)
+		panic("oops")
+	})
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+
+	body, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(body); err != nil || string(b) != long {
+		t.Errorf("Expected a complete stream, got %q (%v)", b, err)
+	}
+}
+
+// notifyRecorder is a ResponseRecorder which can notify of closed connections,
+// but cannot be hijacked, like the ResponseWriters of HTTP/2 connections. It
+// records the informational responses written to it.
+type notifyRecorder struct {
+	*httptest.ResponseRecorder
+	informational []int
+}
+
+func (w *notifyRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *notifyRecorder) WriteHeader(code int) {
+	if code < 200 {
+		w.informational = append(w.informational, code)
+		return
+	}
+	w.ResponseRecorder.WriteHeader(code)
+}
+
+func TestCompressInformational(t *testing.T) {
+	long := strings.Repeat("hello, world ", 100)
+	m := web.New()
+	m.Use(Compress(gzip.DefaultCompression))
+	m.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.CloseNotifier); !ok {
+			t.Error("Expected the writer to be a CloseNotifier")
+		}
+		w.Header().Set("Link", "</style.css>; rel=preload")
+		w.WriteHeader(103) // Early Hints
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, long)
+	})
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := &notifyRecorder{ResponseRecorder: httptest.NewRecorder()}
+	m.ServeHTTP(w, r)
+
+	if len(w.informational) != 1 || w.informational[0] != 103 {
+		t.Errorf("Expected a 103 response, got %v", w.informational)
+	}
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected 201, got %d", w.Code)
+	}
+	if enc := w.Result().Header.Get("Content-Encoding"); enc != "gzip" {
+		t.Errorf(`Expected encoding "gzip", got %q`, enc)
+	}
+}
+
+// identityCompressor is a Compressor which does not compress anything.
+type identityCompressor struct {
+	w io.Writer
+}
+
+func (c *identityCompressor) Write(p []byte) (int, error) { return c.w.Write(p) }
+func (c *identityCompressor) Close() error                { return nil }
+func (c *identityCompressor) Flush() error                { return nil }
+func (c *identityCompressor) Reset(w io.Writer)           { c.w = w }
+
+func TestRegisterEncoding(t *testing.T) {
+	RegisterEncoding("x-test", func() Compressor {
+		return &identityCompressor{}
+	})
+	long := strings.Repeat("hello, world ", 100)
+	m := web.New()
+	m.Use(Compress(gzip.DefaultCompression))
+	m.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, long)
+	})
+
+	for accept, expected := range map[string]string{
+		"gzip, x-test":       "x-test",
+		"gzip, x-test;q=0.5": "gzip",
+		"*":                  "x-test",
+	} {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		if enc := w.Result().Header.Get("Content-Encoding"); enc != expected {
+			t.Errorf("Expected encoding %q for %q, got %q", expected,
+				accept, enc)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/vanackere/slim/web"
+)
+
+// The key under which CORS stores its policy for AutomaticOptions.
+const corsKey ctxkey = "cors"
+
+// CORSOptions configures the middleware returned by CORS.
+type CORSOptions struct {
+	// AllowedOrigins are the origins (e.g., "https://example.com") which
+	// may make cross-origin requests. An origin may contain a "*"
+	// wildcard, which matches any non-empty string, as in
+	// "https://*.example.com", and the origin "*" allows every origin (but
+	// cannot be combined with AllowCredentials).
+	AllowedOrigins []string
+	// AllowedOriginPatterns are regular expressions matching further
+	// allowed origins. They must match the whole origin.
+	AllowedOriginPatterns []*regexp.Regexp
+	// AllowedHeaders are the request headers which may be used in
+	// cross-origin requests, beyond the simple request headers (e.g.,
+	// Accept).
+	AllowedHeaders []string
+	// AllowAllHeaders allows every header the client asks for, whether
+	// or not it is listed in AllowedHeaders.
+	AllowAllHeaders bool
+	// ExposedHeaders are the response headers which scripts may read,
+	// beyond the simple response headers (e.g., Content-Type).
+	ExposedHeaders []string
+	// AllowCredentials allows cross-origin requests to include cookies and
+	// HTTP authentication.
+	AllowCredentials bool
+	// MaxAge is how long the answer to a preflight request may be cached.
+	// Zero leaves it to the client.
+	MaxAge time.Duration
+}
+
+type corsPolicy struct {
+	CORSOptions
+	anyOrigin bool
+	// The allowed origins which contain a wildcard, split around it.
+	wildcards [][2]string
+	// AllowedOriginPatterns, anchored at both ends.
+	patterns []*regexp.Regexp
+}
+
+/*
+CORS returns a middleware that implements Cross-Origin Resource Sharing, which
+allows scripts running on the allowed origins to make requests to the Mux:
+
+	m.Use(middleware.CORS(middleware.CORSOptions{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowCredentials: true,
+	}))
+	m.NotFound(middleware.AutomaticOptions)
+
+Responses to requests from allowed origins are given the appropriate
+Access-Control-Allow-Origin headers, and the requests are otherwise served as
+usual. Preflight requests (OPTIONS requests with an
+Access-Control-Request-Method header) are answered by AutomaticOptions, which
+allows the methods the router would have accepted for the path (see
+web.ValidMethods): this requires AutomaticOptions to be the Mux's NotFound
+handler, and CORS to be added with Use. Preflight requests for paths which have
+an OPTIONS route of their own are passed to that route.
+
+Since browsers would let any web site make requests with the user's credentials,
+CORS panics if both the origin "*" and AllowCredentials are given.
+*/
+func CORS(opts CORSOptions) func(context.Context, http.ResponseWriter, *http.Request, web.Handler) {
+	p := &corsPolicy{CORSOptions: opts}
+	for _, o := range opts.AllowedOrigins {
+		if o == "*" {
+			if opts.AllowCredentials {
+				log.Panicf(`middleware: CORS cannot allow credentials ` +
+					`for the origin "*"`)
+			}
+			p.anyOrigin = true
+		} else if i := strings.IndexByte(o, '*'); i != -1 {
+			p.wildcards = append(p.wildcards,
+				[2]string{strings.ToLower(o[:i]), strings.ToLower(o[i+1:])})
+		}
+	}
+	for _, re := range opts.AllowedOriginPatterns {
+		p.patterns = append(p.patterns,
+			regexp.MustCompile(`\A(?:`+re.String()+`)\z`))
+	}
+
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request, next web.Handler) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTPC(ctx, w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if !p.allowed(origin) {
+			next.ServeHTTPC(ctx, w, r)
+			return
+		}
+
+		if p.anyOrigin {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if p.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method, "+
+				"Access-Control-Request-Headers")
+			ctx = context.WithValue(ctx, corsKey, p)
+		} else if len(p.ExposedHeaders) != 0 {
+			h.Set("Access-Control-Expose-Headers",
+				strings.Join(p.ExposedHeaders, ", "))
+		}
+		next.ServeHTTPC(ctx, w, r)
+	}
+}
+
+func (p *corsPolicy) allowed(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	for _, o := range p.AllowedOrigins {
+		if strings.ToLower(o) == lower {
+			return true
+		}
+	}
+	for _, w := range p.wildcards {
+		if len(lower) > len(w[0])+len(w[1]) &&
+			strings.HasPrefix(lower, w[0]) && strings.HasSuffix(lower, w[1]) {
+			return true
+		}
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// preflight adds the headers answering a preflight request to w, given the
+// methods the router accepts for the request's path.
+func (p *corsPolicy) preflight(w http.ResponseWriter, r *http.Request, methods []string) {
+	method := r.Header.Get("Access-Control-Request-Method")
+	i := 0
+	for i < len(methods) && methods[i] != method {
+		i++
+	}
+	if i == len(methods) {
+		return
+	}
+
+	h := w.Header()
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if req := r.Header.Get("Access-Control-Request-Headers"); p.AllowAllHeaders && req != "" {
+		h.Set("Access-Control-Allow-Headers", req)
+	} else if len(p.AllowedHeaders) != 0 {
+		h.Set("Access-Control-Allow-Headers",
+			strings.Join(p.AllowedHeaders, ", "))
+	}
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age",
+			strconv.Itoa(int(p.MaxAge/time.Second)))
+	}
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vanackere/slim/web"
+)
+
+func TestCORS(t *testing.T) {
+	m := web.New()
+	m.Use(CORS(CORSOptions{
+		AllowedOrigins:        []string{"https://example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`http://localhost:\d+`)},
+		ExposedHeaders:        []string{"X-Total"},
+		AllowCredentials:      true,
+		MaxAge:                time.Hour,
+	}))
+	m.NotFound(AutomaticOptions)
+	m.Get("/users", func(w http.ResponseWriter, r *http.Request) {})
+	m.Put("/users", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, test := range []struct {
+		method, origin, requestMethod string
+		allowOrigin, allowMethods     string
+	}{
+		{"GET", "https://example.com", "", "https://example.com", ""},
+		{"GET", "https://api.example.org", "", "https://api.example.org", ""},
+		{"GET", "https://.example.org", "", "", ""},
+		{"GET", "http://localhost:8080", "", "http://localhost:8080", ""},
+		{"GET", "http://localhost:8080.evil.com", "", "", ""},
+		{"GET", "https://evil.com", "", "", ""},
+		{"OPTIONS", "https://example.com", "PUT", "https://example.com",
+			"GET, HEAD, PUT, OPTIONS"},
+		{"OPTIONS", "https://example.com", "DELETE", "https://example.com", ""},
+		{"OPTIONS", "https://evil.com", "PUT", "", ""},
+	} {
+		r, _ := http.NewRequest(test.method, "/users", nil)
+		r.Header.Set("Origin", test.origin)
+		if test.requestMethod != "" {
+			r.Header.Set("Access-Control-Request-Method", test.requestMethod)
+		}
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+
+		h := w.Result().Header
+		if o := h.Get("Access-Control-Allow-Origin"); o != test.allowOrigin {
+			t.Errorf("Expected origin %q for %s from %s, got %q",
+				test.allowOrigin, test.method, test.origin, o)
+		}
+		if am := h.Get("Access-Control-Allow-Methods"); am != test.allowMethods {
+			t.Errorf("Expected methods %q for %s from %s, got %q",
+				test.allowMethods, test.method, test.origin, am)
+		}
+		if test.allowMethods != "" && h.Get("Access-Control-Max-Age") != "3600" {
+			t.Errorf("Expected a max age of 3600, got %q",
+				h.Get("Access-Control-Max-Age"))
+		}
+		if vary := strings.Join(h["Vary"], ", "); test.requestMethod != "" &&
+			test.allowOrigin != "" && vary != "Origin, Access-Control-Request-Method, "+
+			"Access-Control-Request-Headers" {
+			t.Errorf("Unexpected Vary header %q for a preflight request", vary)
+		}
+		if test.method == "GET" && test.allowOrigin != "" &&
+			h.Get("Access-Control-Expose-Headers") != "X-Total" {
+			t.Errorf("Expected exposed headers for %s, got %q", test.origin,
+				h.Get("Access-Control-Expose-Headers"))
+		}
+	}
+}
+
+func preflight(m http.Handler, origin, headers string) http.Header {
+	r, _ := http.NewRequest("OPTIONS", "/users", nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	if headers != "" {
+		r.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	return w.Result().Header
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	m := web.New()
+	m.Use(CORS(CORSOptions{AllowedOrigins: []string{"*"}}))
+	m.NotFound(AutomaticOptions)
+	m.Get("/users", func(w http.ResponseWriter, r *http.Request) {})
+
+	h := preflight(m, "https://example.com", "X-Token")
+	if o := h.Get("Access-Control-Allow-Origin"); o != "*" {
+		t.Errorf(`Expected origin "*", got %q`, o)
+	}
+	if c := h.Get("Access-Control-Allow-Credentials"); c != "" {
+		t.Errorf("Expected no credentials, got %q", c)
+	}
+	// Requested headers are only allowed with AllowAllHeaders.
+	if ah := h.Get("Access-Control-Allow-Headers"); ah != "" {
+		t.Errorf("Expected no allowed headers, got %q", ah)
+	}
+
+	m = web.New()
+	m.Use(CORS(CORSOptions{AllowedOrigins: []string{"*"}, AllowAllHeaders: true}))
+	m.NotFound(AutomaticOptions)
+	m.Get("/users", func(w http.ResponseWriter, r *http.Request) {})
+	h = preflight(m, "https://example.com", "X-Token")
+	if ah := h.Get("Access-Control-Allow-Headers"); ah != "X-Token" {
+		t.Errorf(`Expected allowed headers "X-Token", got %q`, ah)
+	}
+}
+
+func TestCORSCredentials(t *testing.T) {
+	m := web.New()
+	m.Use(CORS(CORSOptions{
+		AllowedOrigins:   []string{"https://example.com"},
+		AllowedHeaders:   []string{"X-Token"},
+		AllowCredentials: true,
+	}))
+	m.NotFound(AutomaticOptions)
+	m.Get("/users", func(w http.ResponseWriter, r *http.Request) {})
+
+	h := preflight(m, "https://example.com", "X-Token, X-Other")
+	if o := h.Get("Access-Control-Allow-Origin"); o != "https://example.com" {
+		t.Errorf(`Expected origin "https://example.com", got %q`, o)
+	}
+	if c := h.Get("Access-Control-Allow-Credentials"); c != "true" {
+		t.Errorf(`Expected credentials "true", got %q`, c)
+	}
+	if ah := h.Get("Access-Control-Allow-Headers"); ah != "X-Token" {
+		t.Errorf(`Expected allowed headers "X-Token", got %q`, ah)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error(`Expected a panic for credentials with the origin "*"`)
+		}
+	}()
+	CORS(CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"bufio"
+	"os"
+	"sync"
+)
+
+/*
+LogFile is a log file for AccessLog (or any other logger), which can buffer the
+lines written to it and be reopened when it is rotated. Its methods may be called
+concurrently.
+
+Log rotation tools such as logrotate rename the file and then signal the server,
+which is expected to reopen it under its original name:
+
+	logs, err := middleware.OpenLogFile("/var/log/app/access.log", 64*1024)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m.Use(middleware.AccessLog(middleware.CombinedLogFormat, logs))
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := logs.Reopen(); err != nil {
+				log.Print(err)
+			}
+		}
+	}()
+
+Buffered lines only reach the file when the buffer is full, or when Flush,
+Reopen or Close is called: servers which buffer their logs typically flush them
+periodically, and when they shut down.
+*/
+type LogFile struct {
+	path string
+	size int
+
+	lock sync.Mutex
+	f    *os.File
+	// If non-nil, the buffer in front of f.
+	buf *bufio.Writer
+}
+
+// OpenLogFile opens the file at the given path for appending, creating it if
+// necessary. If bufSize is positive, writes to the file are buffered in a buffer
+// of that size.
+func OpenLogFile(path string, bufSize int) (*LogFile, error) {
+	l := &LogFile{path: path, size: bufSize}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *LogFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	l.f = f
+	if l.size > 0 {
+		l.buf = bufio.NewWriterSize(f, l.size)
+	}
+	return nil
+}
+
+// Write appends p to the file, or to its buffer.
+func (l *LogFile) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.buf != nil {
+		return l.buf.Write(p)
+	}
+	return l.f.Write(p)
+}
+
+// Flush writes any buffered data to the file.
+func (l *LogFile) Flush() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.flush()
+}
+
+func (l *LogFile) flush() error {
+	if l.buf == nil {
+		return nil
+	}
+	return l.buf.Flush()
+}
+
+// Reopen flushes and closes the file, and opens the file at the original path
+// again, which is a new file if the previous one has been renamed.
+func (l *LogFile) Reopen() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	err := l.close()
+	if oerr := l.open(); oerr != nil {
+		return oerr
+	}
+	return err
+}
+
+// Close flushes and closes the file.
+func (l *LogFile) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.close()
+}
+
+func (l *LogFile) close() error {
+	err := l.flush()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readLog(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+
+	l, err := OpenLogFile(path, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Write([]byte("one\n"))
+	if s := readLog(t, path); s != "" {
+		t.Errorf("Expected the line to be buffered, got %q", s)
+	}
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if s := readLog(t, path); s != "one\n" {
+		t.Errorf(`Expected "one\n", got %q`, s)
+	}
+
+	// Rotate the file.
+	l.Write([]byte("two\n"))
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	l.Write([]byte("three\n"))
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s := readLog(t, path+".1"); s != "one\ntwo\n" {
+		t.Errorf(`Expected "one\ntwo\n" in the rotated file, got %q`, s)
+	}
+	if s := readLog(t, path); s != "three\n" {
+		t.Errorf(`Expected "three\n" in the new file, got %q`, s)
+	}
+}
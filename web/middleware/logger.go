@@ -22,9 +22,9 @@ import (
 // Logger has been designed explicitly to be Good Enough for use in small
 // applications and for people just getting started with Slim. It is expected
 // that applications will eventually outgrow this middleware and replace it with
-// a custom request logger, such as one that produces machine-parseable output,
-// outputs logs to a different service (e.g., syslog), or formats lines like
-// those printed elsewhere in the application.
+// a custom request logger, such as one that produces machine-parseable output
+// (see StructuredLogger), outputs logs to a different service (e.g., syslog), or
+// formats lines like those printed elsewhere in the application.
 func Logger(ctx context.Context, w http.ResponseWriter, r *http.Request, next web.Handler) {
 	reqID := GetReqID(ctx)
 
@@ -11,13 +11,23 @@ import (
 // AutomaticOptions is a NotFound handler that automatically returns an
 // appropriate "Allow" header when the request method is OPTIONS and the
 // request would have otherwise been 404'd.
-func AutomaticOptions(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+//
+// If the request is a CORS preflight request from an origin allowed by the CORS
+// middleware, AutomaticOptions also answers it with the same methods. Since it
+// is a web.OptionsHandlerFunc, the "Allow" header of the Mux's 405 responses
+// lists OPTIONS as well.
+var AutomaticOptions = web.OptionsHandlerFunc(automaticOptions)
+
+func automaticOptions(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	if r.Method != "OPTIONS" {
 		http.NotFound(w, r)
 		return
 	}
 	methods := addMethod(web.ValidMethods(ctx), "OPTIONS")
 	w.Header().Set("Allow", strings.Join(methods, ", "))
+	if p, ok := ctx.Value(corsKey).(*corsPolicy); ok {
+		p.preflight(w, r, methods)
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
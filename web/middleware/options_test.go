@@ -79,4 +79,18 @@ func TestAutomaticOptions(t *testing.T) {
 			allow)
 	}
 
+	// 405 responses list OPTIONS, since we answer it
+	m.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+	rr = testOptions(m, "PATCH", "/path/2")
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status is %d, not 405", rr.Code)
+	}
+	allow = rr.Result().Header.Get("Allow")
+	correctHeaders = "GET, HEAD, OPTIONS, POST, PUT"
+	if allow != correctHeaders {
+		t.Errorf("Allow header should be %q, was %q", correctHeaders,
+			allow)
+	}
 }
@@ -2,31 +2,136 @@ package middleware
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
 	"log"
 	"net/http"
+	"os"
 	"runtime/debug"
+	"strconv"
+	"strings"
+
 	"code.google.com/p/go.net/context"
 
 	"github.com/vanackere/slim/web"
+	"github.com/vanackere/slim/web/util"
 )
 
+// Panic describes a panic recovered while serving a request.
+type Panic struct {
+	// Value is the value the handler panicked with.
+	Value interface{}
+	// Stack is the stack trace of the panicking goroutine, as formatted by
+	// runtime/debug.Stack.
+	Stack []byte
+	// RequestID is the request's ID (see RequestID), or the empty string.
+	RequestID string
+}
+
+// PanicReporter is told about the panics recovered by the middleware returned by
+// NewRecoverer, for instance to forward them to an error tracking service.
+// ReportPanic is called before the response is written, and may be called
+// concurrently. If it panics in turn, the panic is logged and the error response
+// is written as usual.
+type PanicReporter interface {
+	ReportPanic(ctx context.Context, r *http.Request, p *Panic)
+}
+
+// PanicReporterFunc is an adapter which allows the use of ordinary functions as
+// PanicReporters.
+type PanicReporterFunc func(ctx context.Context, r *http.Request, p *Panic)
+
+// ReportPanic calls f(ctx, r, p).
+func (f PanicReporterFunc) ReportPanic(ctx context.Context, r *http.Request, p *Panic) {
+	f(ctx, r, p)
+}
+
+// RecovererOptions configures the middleware returned by NewRecoverer.
+type RecovererOptions struct {
+	// Reporter is told about every recovered panic. If it is nil, panics
+	// are logged along with their stack trace, as Recoverer does.
+	Reporter PanicReporter
+	// Negotiate enables the negotiation of the format of the error
+	// response: clients which accept "application/json" or "text/html"
+	// (see the Accept header) are answered in that format, which includes
+	// the request ID if there is one. Otherwise, or if Negotiate is false,
+	// the response is in plain text.
+	Negotiate bool
+}
+
 // Recoverer is a middleware that recovers from panics, logs the panic (and a
 // backtrace), and returns a HTTP 500 (Internal Server Error) status if
-// possible.
+// possible, i.e. if the response has not already been started. Panics with
+// http.ErrAbortHandler, with which handlers abort their response on purpose,
+// are passed on to net/http without being reported.
 //
-// Recoverer prints a request ID if one is provided.
+// Recoverer prints a request ID if one is provided. See NewRecoverer for a
+// configurable equivalent.
 func Recoverer(ctx context.Context, w http.ResponseWriter, r *http.Request, next web.Handler) {
-	reqID := GetReqID(ctx)
+	defaultRecoverer(ctx, w, r, next)
+}
+
+var defaultRecoverer = NewRecoverer(RecovererOptions{})
 
+// NewRecoverer returns a middleware that recovers from panics like Recoverer,
+// but which reports them to the given options' Reporter and answers with an
+// error response in the format the client prefers, if so configured.
+//
+// As with Recoverer, no response is written if the handler had already started
+// writing one, since its status can no longer be changed.
+func NewRecoverer(opts RecovererOptions) func(context.Context, http.ResponseWriter, *http.Request, web.Handler) {
+	reporter := opts.Reporter
+	if reporter == nil {
+		reporter = PanicReporterFunc(logPanic)
+	}
+
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request, next web.Handler) {
+		// Other middleware may already keep track of the status.
+		lw, ok := w.(util.WriterProxy)
+		if !ok {
+			lw = util.WrapWriter(w)
+		}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if abortsHandler(err) {
+				panic(err)
+			}
+			reqID := GetReqID(ctx)
+			reportPanic(reporter, ctx, r, &Panic{
+				Value:     err,
+				Stack:     debug.Stack(),
+				RequestID: reqID,
+			})
+			if lw.Status() != 0 {
+				return
+			}
+			if opts.Negotiate {
+				writeError(lw, r, reqID)
+			} else {
+				http.Error(lw, http.StatusText(500), 500)
+			}
+		}()
+
+		next.ServeHTTPC(ctx, lw, r)
+	}
+}
+
+func reportPanic(reporter PanicReporter, ctx context.Context, r *http.Request, p *Panic) {
 	defer func() {
 		if err := recover(); err != nil {
-			printPanic(reqID, err)
-			debug.PrintStack()
-			http.Error(w, http.StatusText(500), 500)
+			log.Printf("middleware: panic while reporting a panic: %+v", err)
 		}
 	}()
+	reporter.ReportPanic(ctx, r, p)
+}
 
-	next.ServeHTTPC(ctx, w, r)
+func logPanic(ctx context.Context, r *http.Request, p *Panic) {
+	printPanic(p.RequestID, p.Value)
+	os.Stderr.Write(p.Stack)
 }
 
 func printPanic(reqID string, err interface{}) {
@@ -39,3 +144,59 @@ func printPanic(reqID string, err interface{}) {
 
 	log.Print(buf.String())
 }
+
+// errorFormat returns the media type, among "application/json" and "text/html",
+// which has the highest quality in the given Accept header, or "text/plain" if
+// the header lists neither of them.
+func errorFormat(accept string) string {
+	format, best := "text/plain", 0.0
+	for _, s := range strings.Split(accept, ",") {
+		mt, q := s, 1.0
+		if i := strings.IndexByte(s, ';'); i != -1 {
+			mt = s[:i]
+			for _, param := range strings.Split(s[i+1:], ";") {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					var err error
+					if q, err = strconv.ParseFloat(param[2:], 64); err != nil {
+						q = 0
+					}
+				}
+			}
+		}
+		mt = strings.ToLower(strings.TrimSpace(mt))
+		if (mt == "application/json" || mt == "text/html") && q > best {
+			format, best = mt, q
+		}
+	}
+	return format
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, reqID string) {
+	const code = http.StatusInternalServerError
+	text := http.StatusText(code)
+
+	switch errorFormat(r.Header.Get("Accept")) {
+	case "application/json":
+		body := struct {
+			Error     string `json:"error"`
+			RequestID string `json:"request_id,omitempty"`
+		}{text, reqID}
+		b, _ := json.Marshal(body)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(code)
+		w.Write(append(b, '\n'))
+	case "text/html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(code)
+		fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><title>%d %s</title></head>"+
+			"<body><h1>%s</h1>", code, text, text)
+		if reqID != "" {
+			fmt.Fprintf(w, "<p>Request ID: <code>%s</code></p>",
+				html.EscapeString(reqID))
+		}
+		fmt.Fprintln(w, "</body></html>")
+	default:
+		http.Error(w, text, code)
+	}
+}
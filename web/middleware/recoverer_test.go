@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/vanackere/slim/web"
+	"github.com/vanackere/slim/web/util"
+)
+
+func TestNewRecoverer(t *testing.T) {
+	var reported []*Panic
+	m := web.New()
+	m.Use(RequestID)
+	m.Use(NewRecoverer(RecovererOptions{
+		Reporter: PanicReporterFunc(func(ctx context.Context, r *http.Request, p *Panic) {
+			reported = append(reported, p)
+		}),
+		Negotiate: true,
+	}))
+	m.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	})
+	m.Get("/started", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("oops")
+	})
+
+	for _, test := range []struct {
+		path, accept string
+		code         int
+		contentType  string
+	}{
+		{"/panic", "", 500, "text/plain; charset=utf-8"},
+		{"/panic", "text/html, application/json;q=0.9", 500, "text/html; charset=utf-8"},
+		{"/panic", "application/json", 500, "application/json; charset=utf-8"},
+		{"/started", "application/json", 202, ""},
+	} {
+		r, _ := http.NewRequest("GET", test.path, nil)
+		r.Header.Set("Accept", test.accept)
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Expected %d for %s (%s), got %d", test.code,
+				test.path, test.accept, w.Code)
+		}
+		if ct := w.Result().Header.Get("Content-Type"); ct != test.contentType {
+			t.Errorf("Expected Content-Type %q for %s (%s), got %q",
+				test.contentType, test.path, test.accept, ct)
+		}
+	}
+
+	if len(reported) != 4 {
+		t.Fatalf("Expected 4 reported panics, got %d", len(reported))
+	}
+	p := reported[0]
+	if p.Value != "oops" || p.RequestID == "" || !strings.Contains(string(p.Stack), "recoverer_test.go") {
+		t.Errorf("Unexpected panic %+v", p)
+	}
+}
+
+func TestRecoverer(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	m := web.New()
+	m.Use(Recoverer)
+	m.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	})
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	if w.Code != 500 || w.Body.String() != "Internal Server Error\n" {
+		t.Errorf("Expected a 500, got %d %q", w.Code, w.Body.String())
+	}
+	if !strings.Contains(buf.String(), "oops") {
+		t.Errorf("Expected the panic to be logged, got %q", buf.String())
+	}
+}
+
+// flushRecorder is a ResponseWriter which can be flushed, but lacks the other
+// optional interfaces.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (f flushRecorder) Flush() {
+	f.ResponseRecorder.Flush()
+}
+
+func TestRecovererWriter(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	m := web.New()
+	m.Use(NewRecoverer(RecovererOptions{
+		Reporter: PanicReporterFunc(func(ctx context.Context, r *http.Request, p *Panic) {
+			panic("reporter")
+		}),
+	}))
+	m.Get("/flush", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("Expected the ResponseWriter to be an http.Flusher")
+		}
+		panic("oops")
+	})
+
+	r, _ := http.NewRequest("GET", "/flush", nil)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(flushRecorder{w}, r)
+	if w.Code != 500 {
+		t.Errorf("Expected a 500 despite the reporter's panic, got %d", w.Code)
+	}
+	if !strings.Contains(buf.String(), "reporter") {
+		t.Errorf("Expected the reporter's panic to be logged, got %q", buf.String())
+	}
+
+	// An existing WriterProxy is reused.
+	var proxy util.WriterProxy
+	m = web.New()
+	m.Use(func(h web.Handler) web.Handler {
+		return web.HandlerFunc(func(c context.Context, w http.ResponseWriter, r *http.Request) {
+			proxy = util.WrapWriter(w)
+			h.ServeHTTPC(c, proxy, r)
+		})
+	})
+	m.Use(NewRecoverer(RecovererOptions{
+		Reporter: PanicReporterFunc(func(ctx context.Context, r *http.Request, p *Panic) {}),
+	}))
+	m.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		if w != proxy {
+			t.Error("Expected the WriterProxy to be reused")
+		}
+		panic("oops")
+	})
+	r, _ = http.NewRequest("GET", "/", nil)
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	if proxy.Status() != 500 {
+		t.Errorf("Expected a status of 500, got %d", proxy.Status())
+	}
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/vanackere/slim/web"
+	"github.com/vanackere/slim/web/util"
+)
+
+// LogEntry describes a request served through the middleware returned by
+// StructuredLogger.
+type LogEntry struct {
+	// Time is the time at which the request started.
+	Time time.Time `json:"time"`
+	// RequestID is the request's ID (see RequestID), or the empty string.
+	RequestID string `json:"request_id,omitempty"`
+	Method    string `json:"method"`
+	// Path is the path of the request, as it was received.
+	Path string `json:"path"`
+	// Route is the pattern of the route the request was routed to (see
+	// web.MatchedRoute), or the empty string if it matched no route.
+	Route  string `json:"route,omitempty"`
+	Status int    `json:"status"`
+	// Bytes is the number of bytes of the response body.
+	Bytes int `json:"bytes"`
+	// Latency is the time it took to serve the request. In JSON, it is
+	// given in (fractional) seconds.
+	Latency    time.Duration `json:"-"`
+	RemoteAddr string        `json:"remote_addr"`
+}
+
+// LogSink is the destination of the entries produced by StructuredLogger. Sinks
+// are called concurrently, once per request, after the request has been served.
+type LogSink interface {
+	WriteEntry(e *LogEntry)
+}
+
+// LogSinkFunc is an adapter which allows the use of ordinary functions as
+// LogSinks.
+type LogSinkFunc func(e *LogEntry)
+
+// WriteEntry calls f(e).
+func (f LogSinkFunc) WriteEntry(e *LogEntry) {
+	f(e)
+}
+
+/*
+StructuredLogger returns a middleware that hands a single LogEntry per request to
+the given sink, once the request has been served. Unlike Logger, which writes
+free-form lines meant to be read by humans, it is intended for applications
+whose logs are processed by machines:
+
+	m.Use(middleware.RequestID)
+	m.Use(middleware.StructuredLogger(middleware.JSONSink(os.Stderr)))
+
+Since it needs to see every request, including those which are not routed,
+StructuredLogger is best added with Use (and after RequestID and RealIP, if they
+are used). The route of the entries is still that of the route which served the
+request (see web.CaptureRoute).
+*/
+func StructuredLogger(sink LogSink) func(context.Context, http.ResponseWriter, *http.Request, web.Handler) {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request, next web.Handler) {
+		e := LogEntry{
+			Time:       time.Now(),
+			RequestID:  GetReqID(ctx),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			RemoteAddr: r.RemoteAddr,
+		}
+		lw := util.WrapWriter(w)
+		c, matched := web.CaptureRoute(ctx)
+
+		next.ServeHTTPC(c, lw, r)
+
+		e.Latency = time.Since(e.Time)
+		if route, ok := matched(); ok {
+			e.Route = route.Pattern
+		}
+		e.Status = lw.Status()
+		if e.Status == 0 {
+			e.Status = http.StatusOK
+		}
+		e.Bytes = lw.BytesWritten()
+		sink.WriteEntry(&e)
+	}
+}
+
+// lineSink writes entries formatted by format to w, one per line, and
+// serializes the writes so that lines are never interleaved.
+type lineSink struct {
+	lock   sync.Mutex
+	w      io.Writer
+	buf    bytes.Buffer
+	format func(buf *bytes.Buffer, e *LogEntry)
+}
+
+func (s *lineSink) WriteEntry(e *LogEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.buf.Reset()
+	s.format(&s.buf, e)
+	s.buf.WriteByte('\n')
+	s.w.Write(s.buf.Bytes())
+}
+
+// JSONSink returns a LogSink which writes each entry to w as a JSON object on a
+// line of its own, with the keys given by the tags of LogEntry's fields, and a
+// "latency" key holding the latency in seconds.
+func JSONSink(w io.Writer) LogSink {
+	return &lineSink{w: w, format: formatJSON}
+}
+
+func formatJSON(buf *bytes.Buffer, e *LogEntry) {
+	b, _ := json.Marshal(struct {
+		*LogEntry
+		Latency float64 `json:"latency"`
+	}{e, e.Latency.Seconds()})
+	buf.Write(b)
+}
+
+// LogfmtSink returns a LogSink which writes each entry to w in logfmt, i.e. as a
+// line of space-separated key=value pairs, using the same keys as JSONSink.
+// Empty values are omitted, and the latency is formatted like a time.Duration
+// (e.g., "1.5ms").
+func LogfmtSink(w io.Writer) LogSink {
+	return &lineSink{w: w, format: formatLogfmt}
+}
+
+func formatLogfmt(buf *bytes.Buffer, e *LogEntry) {
+	logfmtPair(buf, "time", e.Time.Format(time.RFC3339Nano))
+	logfmtPair(buf, "request_id", e.RequestID)
+	logfmtPair(buf, "method", e.Method)
+	logfmtPair(buf, "path", e.Path)
+	logfmtPair(buf, "route", e.Route)
+	logfmtPair(buf, "status", strconv.Itoa(e.Status))
+	logfmtPair(buf, "bytes", strconv.Itoa(e.Bytes))
+	logfmtPair(buf, "latency", e.Latency.String())
+	logfmtPair(buf, "remote_addr", e.RemoteAddr)
+}
+
+func logfmtPair(buf *bytes.Buffer, key, value string) {
+	if value == "" {
+		return
+	}
+	if buf.Len() != 0 {
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(key)
+	buf.WriteByte('=')
+	if strings.IndexFunc(value, needsQuoting) != -1 {
+		buf.WriteString(strconv.Quote(value))
+	} else {
+		buf.WriteString(value)
+	}
+}
+
+func needsQuoting(r rune) bool {
+	return r <= ' ' || r == '=' || r == '"' || r >= 0x7f
+}
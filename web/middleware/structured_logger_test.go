@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/vanackere/slim/web"
+)
+
+func TestStructuredLogger(t *testing.T) {
+	var entries []LogEntry
+	m := web.New()
+	m.Use(StructuredLogger(LogSinkFunc(func(e *LogEntry) {
+		entries = append(entries, *e)
+	})))
+	m.Get("/users/:name", func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	for _, path := range []string{"/users/carl", "/nope"} {
+		r, _ := http.NewRequest("GET", path, nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		m.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Method != "GET" || e.Path != "/users/carl" || e.Route != "/users/:name" ||
+		e.Status != 200 || e.Bytes != 5 || e.RemoteAddr != "10.0.0.1:1234" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	e = entries[1]
+	if e.Path != "/nope" || e.Route != "" || e.Status != 404 {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+}
+
+func TestLogSinks(t *testing.T) {
+	e := &LogEntry{
+		RequestID: "host/abc-000001",
+		Method:    "GET",
+		Path:      "/a path",
+		Route:     "/:name",
+		Status:    200,
+		Bytes:     12,
+		Latency:   1500000,
+	}
+
+	var buf bytes.Buffer
+	JSONSink(&buf).WriteEntry(e)
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", buf.String(), err)
+	}
+	if m["route"] != "/:name" || m["latency"] != 0.0015 || m["status"] != 200.0 {
+		t.Errorf("Unexpected JSON entry %q", buf.String())
+	}
+
+	buf.Reset()
+	LogfmtSink(&buf).WriteEntry(e)
+	expected := ` request_id=host/abc-000001 method=GET path="/a path" ` +
+		`route=/:name status=200 bytes=12 latency=1.5ms` + "\n"
+	if !strings.HasSuffix(buf.String(), expected) {
+		t.Errorf("Expected logfmt entry ending in %q, got %q", expected,
+			buf.String())
+	}
+}
@@ -0,0 +1,114 @@
+package web
+
+import (
+	"net/http"
+
+	"code.google.com/p/go.net/context"
+)
+
+// mountPoint records where a Mux has been mounted with Mux.Mount.
+type mountPoint struct {
+	parent *router
+	// The pattern the Mux was mounted under, including the trailing "/*".
+	pattern stringPattern
+}
+
+// mountedMux is the handler used on the parent's side of a mount point.
+type mountedMux struct {
+	mux *Mux
+}
+
+func (h mountedMux) ServeHTTPC(c context.Context, w http.ResponseWriter, r *http.Request) {
+	params := URLParams(c)
+
+	if _, ok := c.Value(mountPathKey).(string); !ok {
+		c = context.WithValue(c, mountPathKey, r.URL.Path)
+	}
+	// The request may be shared with the parent's handlers (for instance
+	// middleware which logs its path once it has been served), so the
+	// sub-mux gets a copy of it instead.
+	r2 := *r
+	u := *r.URL
+	u.Path = params["*"]
+	u.RawPath = ""
+	r2.URL = &u
+
+	// The parent's "*" describes the parent's match, and would only
+	// confuse the sub-mux's handlers.
+	inherit := make(inheritedParams, len(params))
+	for k, v := range params {
+		if k != "*" {
+			inherit[k] = v
+		}
+	}
+	c = context.WithValue(c, paramKey, inherit)
+
+	// Until the sub-mux routes the request, no route has matched it: the
+	// parent's route under which the sub-mux is mounted is an
+	// implementation detail of Mount.
+	c = context.WithValue(c, routeKey, (*matchedRoute)(nil))
+	if capture, ok := c.Value(captureKey).(*routeCapture); ok {
+		capture.m = matchedRoute{}
+	}
+
+	h.mux.ServeHTTPC(c, w, &r2)
+}
+
+func (rt *router) getMount() *mountPoint {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return rt.mount
+}
+
+// mountedPath turns a path relative to the router into an absolute one, by
+// reversing the patterns of each of the mount points above it.
+func (rt *router) mountedPath(path string, params map[string]string) (string, error) {
+	for mp := rt.getMount(); mp != nil; mp = mp.parent.getMount() {
+		p := make(map[string]string, len(params)+1)
+		for k, v := range params {
+			p[k] = v
+		}
+		p["*"] = path
+
+		var err error
+		if path, err = mp.pattern.buildPath(p); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// getNotFound returns the router's NotFound handler. Routers which do not have
+// one use the handler of the router they are mounted on, if any.
+func (rt *router) getNotFound() Handler {
+	if nf := rt.inherit(func(rt *router) Handler { return rt.notFound }); nf != nil {
+		return nf
+	}
+	return defaultNotFound
+}
+
+// getMethodNotAllowed returns the router's MethodNotAllowed handler, which is
+// inherited in the same way as the NotFound handler. It returns nil if no such
+// handler was set.
+func (rt *router) getMethodNotAllowed() Handler {
+	return rt.inherit(func(rt *router) Handler { return rt.methodNotAllowed })
+}
+
+func (rt *router) inherit(field func(*router) Handler) Handler {
+	for ; rt != nil; rt = rt.parent() {
+		rt.lock.Lock()
+		h := field(rt)
+		rt.lock.Unlock()
+		if h != nil {
+			return h
+		}
+	}
+	return nil
+}
+
+func (rt *router) parent() *router {
+	if mp := rt.getMount(); mp != nil {
+		return mp.parent
+	}
+	return nil
+}
@@ -1,7 +1,9 @@
 package web
 
 import (
+	"log"
 	"net/http"
+	"strings"
 
 	"code.google.com/p/go.net/context"
 )
@@ -16,18 +18,23 @@ that matches both the request's path and method is used.
 There are two other differences worth mentioning between web.Mux and
 http.ServeMux. First, string patterns (i.e., Sinatra-like patterns) must match
 exactly: the "rooted subtree" behavior of ServeMux is not implemented. Secondly,
-unlike ServeMux, Mux does not support Host-specific patterns.
+unlike ServeMux, patterns do not include a host: Host-specific routes can
+instead be added by wrapping a pattern with Host.
 
 If you require any of these features, remember that you are free to mix and
 match muxes at any part of the stack.
 
+Both routes and middleware may be added or removed at any time, including while
+requests are being served. Each request is served using the routes and the
+middleware stack that were in place when it started.
+
 In order to provide a sane API, many functions on Mux take interface{}'s. This
 is obviously not a very satisfying solution, but it's probably the best we can
 do for now. Instead of duplicating documentation on each method, the types
 accepted by those functions are documented here.
 
-A middleware (the untyped parameter in Use() and Insert()) must be one of the
-following types:
+A middleware (the untyped parameter in Use(), Insert(), UseRouted() and
+InsertRouted()) must be one of the following types:
 	- func(http.Handler) http.Handler
 	- func(web.Handler) web.Handler
 All of the route-adding functions on Mux take two untyped parameters: pattern
@@ -37,6 +44,14 @@ and handler. Pattern must be one of the following types:
 		- a path segment starting with with a colon will match any
 		  string placed at that position. e.g., "/:name" will match
 		  "/carl", binding "name" to "carl".
+		- a named segment may be followed by a constraint in braces,
+		  in which case the segment only matches if its value
+		  satisfies the constraint. A constraint is either a regular
+		  expression, which must match the whole value (e.g.,
+		  "/files/:slug{[a-z0-9-]+}"), or one of the predefined names
+		  "int", "uint", "hex", "alpha", "alnum" and "uuid" (e.g.,
+		  "/users/:id{int}"). Requests which do not satisfy the
+		  constraint are routed as if the route did not exist.
 		- a pattern ending with "/*" will match any route with that
 		  prefix. For instance, the pattern "/u/:name/*" will match
 		  "/u/carl/" and "/u/carl/projects/123", but not "/u/carl"
@@ -45,6 +60,22 @@ and handler. Pattern must be one of the following types:
 		  unmatched tail of the match, but including the leading "/". So
 		  for the two matching examples above, "*" would be bound to "/"
 		  and "/projects/123" respectively.
+		- the "*" may be followed by a name, in which case the tail is
+		  bound to that name instead of "*". e.g., "/static/*filepath"
+		  will match "/static/css/site.css", binding "filepath" to
+		  "/css/site.css".
+		- a named or unnamed catch-all may also appear in the middle of
+		  a pattern, where it matches one or more path segments (as
+		  many as possible), including their leading "/". e.g.,
+		  "/repos/*path/blob/:ref" will match "/repos/a/b/blob/master",
+		  binding "path" to "/a/b" and "ref" to "master". A pattern
+		  may only have one such catch-all.
+		- a part of the pattern starting with a slash may be made
+		  optional by enclosing it in parentheses. e.g.,
+		  "/posts(/:page)" will match both "/posts" and "/posts/2", and
+		  optional parts may be nested, as in
+		  "/archive(/:year(/:month))". Parameters in optional parts
+		  which are left out are not bound.
 	- regexp.Regexp. The library assumes that it is a Perl-style regexp that
 	  is anchored on the left (i.e., the beginning of the string). If your
 	  regexp is not anchored on the left, a hopefully-identical
@@ -55,10 +86,19 @@ Handler must be one of the following types:
 	- web.Handler
 	- func(w http.ResponseWriter, r *http.Request)
 	- func(c context.Context, w http.ResponseWriter, r *http.Request)
+	- func(c context.Context, w http.ResponseWriter, r *http.Request) error,
+	  whose errors are answered as described for ErrorHandlerFunc
+Each of the route-adding functions returns a *Route, which can be used to
+further configure the route that was just added. For instance, a route can be
+given a name, which can later be passed to URL in order to build a path that
+the route would match:
+	m.Get("/users/:name", showUser).Name("user")
+	path, err := m.URL("user", map[string]string{"name": "carl"})
 */
 type Mux struct {
-	ms mStack
-	rt router
+	ms   mStack
+	post mStack
+	rt   router
 }
 
 // New creates a new Mux without any routes or middleware.
@@ -68,30 +108,37 @@ func New() *Mux {
 			stack: make([]interface{}, 0),
 			pool:  makeCPool(),
 		},
+		post: mStack{
+			stack:  make([]interface{}, 0),
+			pool:   makeCPool(),
+			router: matchedHandler{},
+		},
 		rt: router{
-			routes:   make([]route, 0),
-			notFound: parseHandler(http.NotFound),
+			routes: make([]*route, 0),
+			names:  make(map[string]*route),
 		},
 	}
 	mux.ms.router = &mux.rt
+	mux.rt.post = &mux.post
 	return &mux
 }
 
 var bgctx = context.Background()
 
 // ServeHTTP processes HTTP requests. It make Muxes satisfy net/http.Handler.
+//
+// Since Go 1.7, requests are served in the context of the request (see
+// http.Request's Context method). Conversely, if RequestContext is enabled, the
+// request handed to net/http handlers and middleware carries the context it is
+// being served in.
 func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	stack := m.ms.alloc()
-	stack.ServeHTTPC(bgctx, w, r)
-	m.ms.release(stack)
+	m.ms.ServeHTTPC(baseContext(r), w, r)
 }
 
 // ServeHTTPC creates a context dependent request with the given Mux. Satisfies
 // the web.Handler interface.
 func (m *Mux) ServeHTTPC(c context.Context, w http.ResponseWriter, r *http.Request) {
-	stack := m.ms.alloc()
-	stack.ServeHTTPC(c, w, r)
-	m.ms.release(stack)
+	m.ms.ServeHTTPC(c, w, r)
 }
 
 // Middleware Stack functions
@@ -99,8 +146,7 @@ func (m *Mux) ServeHTTPC(c context.Context, w http.ResponseWriter, r *http.Reque
 // Append the given middleware to the middleware stack. See the documentation
 // for type Mux for a list of valid middleware types.
 //
-// No attempt is made to enforce the uniqueness of middlewares. It is illegal to
-// call this function concurrently with active requests.
+// No attempt is made to enforce the uniqueness of middlewares.
 func (m *Mux) Use(middleware interface{}) {
 	m.ms.Use(middleware)
 }
@@ -110,8 +156,7 @@ func (m *Mux) Use(middleware interface{}) {
 // types. Returns an error if no middleware has the name given by "before."
 //
 // No attempt is made to enforce the uniqueness of middlewares. If the insertion
-// point is ambiguous, the first (outermost) one is chosen. It is illegal to
-// call this function concurrently with active requests.
+// point is ambiguous, the first (outermost) one is chosen.
 func (m *Mux) Insert(middleware, before interface{}) error {
 	return m.ms.Insert(middleware, before)
 }
@@ -120,12 +165,38 @@ func (m *Mux) Insert(middleware, before interface{}) error {
 // no such middleware can be found.
 //
 // If the name of the middleware to delete is ambiguous, the first (outermost)
-// one is chosen. It is illegal to call this function concurrently with active
-// requests.
+// one is chosen.
 func (m *Mux) Abandon(middleware interface{}) error {
 	return m.ms.Abandon(middleware)
 }
 
+// UseRouted appends the given middleware to the post-routing middleware stack.
+// Unlike the middleware added with Use, which runs before the request is routed,
+// post-routing middleware runs once a route has been selected, and only wraps
+// the handlers of routes: it can therefore use URLParams, RouteValue and
+// MatchedRoute, but is not called for requests which are passed to the NotFound
+// or MethodNotAllowed handlers. It runs before the middleware of the route's
+// group and of the route itself, if any. See the documentation for type Mux for
+// a list of valid middleware types.
+//
+// No attempt is made to enforce the uniqueness of middlewares.
+func (m *Mux) UseRouted(middleware interface{}) {
+	m.post.Use(middleware)
+}
+
+// InsertRouted inserts the given middleware immediately before a given existing
+// middleware in the post-routing middleware stack (see UseRouted). Returns an
+// error if no middleware has the name given by "before."
+func (m *Mux) InsertRouted(middleware, before interface{}) error {
+	return m.post.Insert(middleware, before)
+}
+
+// AbandonRouted removes the given middleware from the post-routing middleware
+// stack (see UseRouted). Returns an error if no such middleware can be found.
+func (m *Mux) AbandonRouted(middleware interface{}) error {
+	return m.post.Abandon(middleware)
+}
+
 // Router functions
 
 /*
@@ -140,22 +211,64 @@ doesn't strip this prefix from the path before forwarding it on (e.g., the
 handler will see the full path, including the "/admin" part), but this
 functionality can easily be performed by an extra middleware layer.
 */
-func (m *Mux) Handle(pattern interface{}, handler interface{}) {
-	m.rt.handleUntyped(pattern, mALL, handler)
+func (m *Mux) Handle(pattern interface{}, handler interface{}) *Route {
+	return m.rt.handleUntyped(pattern, mALL, handler)
+}
+
+/*
+Mount the given Mux under the given prefix, which may be any Sinatra-like
+pattern. Requests for paths below the prefix, regardless of their HTTP method,
+are dispatched to the sub-mux with the prefix stripped from their path: if
+admin is mounted under "/admin", a request for "/admin/users" is routed by admin
+as if it were for "/users". Note that a request for "/admin" itself is not.
+
+The URL parameters bound by the prefix remain visible to the sub-mux's handlers
+alongside their own, paths built with the sub-mux's URL function include the
+prefix, and if the sub-mux has no NotFound handler of its own, the parent's is
+used, which makes handlers such as middleware.AutomaticOptions see the methods
+the sub-mux would have accepted.
+
+A Mux may only be mounted once: Mount panics if sub has already been mounted.
+*/
+func (m *Mux) Mount(prefix string, sub *Mux) *Route {
+	pattern := parseStringPattern(strings.TrimSuffix(prefix, "/") + "/*")
+
+	sub.rt.lock.Lock()
+	if mp := sub.rt.mount; mp != nil {
+		sub.rt.lock.Unlock()
+		log.Panicf("web: Mux is already mounted under %q", mp.pattern.raw)
+	}
+	sub.rt.mount = &mountPoint{parent: &m.rt, pattern: pattern}
+	sub.rt.lock.Unlock()
+
+	return m.rt.handle(pattern, mALL, mountedMux{sub}, sub, nil)
+}
+
+// Dispatch to the given handler when the pattern matches and the HTTP method is
+// the given one, which may be either one of the standard methods or a custom
+// method like "PROPFIND". Custom methods are registered automatically (see
+// RegisterMethod). See the documentation for type Mux for a description of what
+// types are accepted for pattern and handler.
+//
+// Unlike Get, Method("GET", ...) does not also serve HEAD requests. Adding a
+// route for a method which has not yet been registered is subject to the same
+// restrictions as RegisterMethod.
+func (m *Mux) Method(method string, pattern interface{}, handler interface{}) *Route {
+	return m.rt.handleUntyped(pattern, methodFor(method), handler)
 }
 
 // Dispatch to the given handler when the pattern matches and the HTTP method is
 // CONNECT. See the documentation for type Mux for a description of what types
 // are accepted for pattern and handler.
-func (m *Mux) Connect(pattern interface{}, handler interface{}) {
-	m.rt.handleUntyped(pattern, mCONNECT, handler)
+func (m *Mux) Connect(pattern interface{}, handler interface{}) *Route {
+	return m.rt.handleUntyped(pattern, mCONNECT, handler)
 }
 
 // Dispatch to the given handler when the pattern matches and the HTTP method is
 // DELETE. See the documentation for type Mux for a description of what types
 // are accepted for pattern and handler.
-func (m *Mux) Delete(pattern interface{}, handler interface{}) {
-	m.rt.handleUntyped(pattern, mDELETE, handler)
+func (m *Mux) Delete(pattern interface{}, handler interface{}) *Route {
+	return m.rt.handleUntyped(pattern, mDELETE, handler)
 }
 
 // Dispatch to the given handler when the pattern matches and the HTTP method is
@@ -166,67 +279,180 @@ func (m *Mux) Delete(pattern interface{}, handler interface{}) {
 // take care of all the fiddly bits for you. If you wish to provide an alternate
 // implementation of HEAD, you should add a handler explicitly and place it
 // above your GET handler.
-func (m *Mux) Get(pattern interface{}, handler interface{}) {
-	m.rt.handleUntyped(pattern, mGET|mHEAD, handler)
+func (m *Mux) Get(pattern interface{}, handler interface{}) *Route {
+	return m.rt.handleUntyped(pattern, mGET|mHEAD, handler)
 }
 
 // Dispatch to the given handler when the pattern matches and the HTTP method is
 // HEAD. See the documentation for type Mux for a description of what types are
 // accepted for pattern and handler.
-func (m *Mux) Head(pattern interface{}, handler interface{}) {
-	m.rt.handleUntyped(pattern, mHEAD, handler)
+func (m *Mux) Head(pattern interface{}, handler interface{}) *Route {
+	return m.rt.handleUntyped(pattern, mHEAD, handler)
 }
 
 // Dispatch to the given handler when the pattern matches and the HTTP method is
 // OPTIONS. See the documentation for type Mux for a description of what types
 // are accepted for pattern and handler.
-func (m *Mux) Options(pattern interface{}, handler interface{}) {
-	m.rt.handleUntyped(pattern, mOPTIONS, handler)
+func (m *Mux) Options(pattern interface{}, handler interface{}) *Route {
+	return m.rt.handleUntyped(pattern, mOPTIONS, handler)
 }
 
 // Dispatch to the given handler when the pattern matches and the HTTP method is
 // PATCH. See the documentation for type Mux for a description of what types are
 // accepted for pattern and handler.
-func (m *Mux) Patch(pattern interface{}, handler interface{}) {
-	m.rt.handleUntyped(pattern, mPATCH, handler)
+func (m *Mux) Patch(pattern interface{}, handler interface{}) *Route {
+	return m.rt.handleUntyped(pattern, mPATCH, handler)
 }
 
 // Dispatch to the given handler when the pattern matches and the HTTP method is
 // POST. See the documentation for type Mux for a description of what types are
 // accepted for pattern and handler.
-func (m *Mux) Post(pattern interface{}, handler interface{}) {
-	m.rt.handleUntyped(pattern, mPOST, handler)
+func (m *Mux) Post(pattern interface{}, handler interface{}) *Route {
+	return m.rt.handleUntyped(pattern, mPOST, handler)
 }
 
 // Dispatch to the given handler when the pattern matches and the HTTP method is
 // PUT. See the documentation for type Mux for a description of what types are
 // accepted for pattern and handler.
-func (m *Mux) Put(pattern interface{}, handler interface{}) {
-	m.rt.handleUntyped(pattern, mPUT, handler)
+func (m *Mux) Put(pattern interface{}, handler interface{}) *Route {
+	return m.rt.handleUntyped(pattern, mPUT, handler)
 }
 
 // Dispatch to the given handler when the pattern matches and the HTTP method is
 // TRACE. See the documentation for type Mux for a description of what types are
 // accepted for pattern and handler.
-func (m *Mux) Trace(pattern interface{}, handler interface{}) {
-	m.rt.handleUntyped(pattern, mTRACE, handler)
+func (m *Mux) Trace(pattern interface{}, handler interface{}) *Route {
+	return m.rt.handleUntyped(pattern, mTRACE, handler)
 }
 
-// Set the fallback (i.e., 404) handler for this mux. See the documentation for
+// Set the fallback (i.e., 404) handler for this mux. Muxes which have been
+// mounted on another Mux (see Mount) and which do not have a fallback handler of
+// their own use their parent's. See the documentation for
 // type Mux for a description of what types are accepted for handler.
 //
-// As a convenience, the context environment variable "goji.web.validMethods"
-// (also available as the constant ValidMethodsKey) will be set to the list of
-// HTTP methods that could have been routed had they been provided on an
-// otherwise identical request.
+// As a convenience, ValidMethods returns the list of HTTP methods that could
+// have been routed had they been provided on an otherwise identical OPTIONS
+// request.
 func (m *Mux) NotFound(handler interface{}) {
-	m.rt.notFound = parseHandler(handler)
+	h := parseHandler(handler)
+	m.rt.lock.Lock()
+	m.rt.notFound = h
+	m.rt.lock.Unlock()
+}
+
+// URL builds the path of the route registered under the given name (see
+// Route.Name), substituting the given parameters into its pattern. The returned
+// path is escaped and can be used as-is in links or redirects.
+//
+// Both Sinatra-like patterns and regular expressions can be reversed. For the
+// former, every named parameter (and "*" for patterns ending in "/*") must be
+// present in params; for the latter, every named capture group. An error is
+// returned if a parameter is missing, if a parameter's value would not be
+// matched by the pattern, or if no route has the given name. Parameters that
+// the pattern does not use are ignored.
+func (m *Mux) URL(name string, params map[string]string) (string, error) {
+	return m.rt.url(name, params)
+}
+
+// Routes returns a description of every route registered on this mux, in the
+// order in which the router considers them. Since routes are only ever
+// reordered when doing so cannot change which route a request is dispatched
+// to, this is not necessarily the order in which they were added.
+func (m *Mux) Routes() []RouteInfo {
+	return m.rt.routeInfos()
+}
+
+// Set the handler for requests whose path is matched by one or more routes, but
+// whose HTTP method is not. See the documentation for type Mux for a description
+// of what types are accepted for handler.
+//
+// By default no such handler is set, and these requests are treated like any
+// other request that could not be routed, i.e., they are passed to the NotFound
+// handler. Once a handler is set, the "Allow" header of the response is set to
+// the list of methods that could have been routed before the handler is called,
+// and ValidMethods returns the same list. OPTIONS requests are never passed to
+// this handler, so that they can be answered by the NotFound handler (for
+// instance by middleware.AutomaticOptions, in which case the list includes
+// OPTIONS; see OptionsHandlerFunc).
+//
+// As with NotFound handlers, Muxes which have been mounted on another Mux and
+// which do not have a handler of their own use their parent's.
+func (m *Mux) MethodNotAllowed(handler interface{}) {
+	h := parseHandler(handler)
+	m.rt.lock.Lock()
+	m.rt.methodNotAllowed = h
+	m.rt.lock.Unlock()
+}
+
+// Redirect requests which cannot be routed, but which could be if their path was
+// cleaned up, with the given HTTP status code. A code of 0 (the default)
+// disables this behavior. Since clients turn POST requests redirected with 301
+// (http.StatusMovedPermanently) into GET requests, 308 (Permanent Redirect) is
+// usually the better choice for APIs.
+//
+// Two variants of the path are tried, in order: the path with duplicate
+// slashes and "." and ".." elements removed (see path.Clean), and that cleaned
+// path with its trailing slash added or removed. The query string is
+// preserved. For instance, if a route matches "/users/:name", a request for
+// "/users//carl/" will be redirected to "/users/carl".
+func (m *Mux) RedirectPaths(code int) {
+	m.rt.lock.Lock()
+	m.rt.redirectCode = code
+	m.rt.lock.Unlock()
+}
+
+/*
+TreeRouting selects the algorithm used to route requests. By default, routes
+are compiled into bytecode for a state machine, which is fast for the small to
+medium numbers of routes most applications have, but whose performance degrades
+as routes accumulate, since routes sharing a prefix must all be examined in
+turn.
+
+If enabled, Sinatra-like patterns whose parameters each span a whole path
+segment (like "/users/:name/posts/*") are instead indexed in a tree of path
+segments, so that only the routes which can match a request's path are
+examined, however many routes there are. Other routes are examined in turn as
+before. Either way, routes are considered in the same order, and the first
+matching route wins.
+
+TreeRouting may be called at any time, and causes the routes to be compiled
+again on the next request.
+*/
+func (m *Mux) TreeRouting(enabled bool) {
+	m.rt.lock.Lock()
+	m.rt.treeRouting = enabled
+	m.rt.setMachine(nil)
+	m.rt.lock.Unlock()
+}
+
+/*
+RequestContext selects whether the context requests are served in is stored on
+the requests handed to net/http handlers and middleware (since Go 1.7), so that
+the URL parameters and any values set by middleware can be retrieved from them:
+
+	m.RequestContext(true)
+	m.Get("/users/:name", func(w http.ResponseWriter, r *http.Request) {
+		name, _ := web.URLParam(r.Context(), "name")
+	})
+
+This requires a copy of the request (and therefore an allocation) each time the
+context changed before the request is passed to an http.Handler, for instance
+once it has been routed, which handlers of type web.Handler avoid. It is
+therefore disabled by default, in which case net/http handlers and middleware
+get the request as it was given to the Mux. Routes of Muxes mounted on a Mux
+for which RequestContext is enabled also store their contexts on requests.
+*/
+func (m *Mux) RequestContext(enabled bool) {
+	m.ms.lock.Lock()
+	m.ms.requestContext = enabled
+	m.ms.invalidate()
+	m.ms.lock.Unlock()
 }
 
 // Compile the list of routes into bytecode. This only needs to be done once
 // after all the routes have been added, and will be called automatically for
 // you (at some performance cost on the first request) if you do not call it
-// explicitly.
+// explicitly. Compile does not check the routes for conflicts: see Validate.
 func (m *Mux) Compile() {
 	m.rt.compile()
 }
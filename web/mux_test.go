@@ -3,6 +3,7 @@ package web
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"code.google.com/p/go.net/context"
@@ -46,3 +47,201 @@ func TestIfItWorks(t *testing.T) {
 		t.Errorf(`Unexpected response %q, expected "Yo bob"`, out)
 	}
 }
+
+func TestMount(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	admin := New()
+	ch := make(chan string, 1)
+
+	m.Mount("/u/:user/admin", admin)
+	admin.Get("/posts/:id", func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		p := URLParams(c)
+		ch <- r.URL.Path + " " + p["user"] + " " + p["id"] + " " + p["*"]
+	}).Name("post")
+	admin.Post("/", http.NotFound)
+
+	r, _ := http.NewRequest("GET", "/u/carl/admin/posts/42", nil)
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	if out := <-ch; out != "/posts/42 carl 42 " {
+		t.Errorf(`Unexpected response %q, expected "/posts/42 carl 42 "`, out)
+	}
+	if r.URL.Path != "/u/carl/admin/posts/42" {
+		t.Errorf("Path was not restored, got %q", r.URL.Path)
+	}
+
+	url, err := admin.URL("post", map[string]string{"user": "bob", "id": "7"})
+	if err != nil || url != "/u/bob/admin/posts/7" {
+		t.Errorf(`Expected "/u/bob/admin/posts/7", got %q (%v)`, url, err)
+	}
+	if _, err := admin.URL("post", map[string]string{"id": "7"}); err == nil {
+		t.Error("Expected an error for a missing mount point parameter")
+	}
+
+	m.NotFound(func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		ch <- strings.Join(ValidMethods(c), ",")
+	})
+	r, _ = http.NewRequest("OPTIONS", "/u/carl/admin/", nil)
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	if out := <-ch; out != "POST" {
+		t.Errorf(`Expected the parent's NotFound to see "POST", got %q`, out)
+	}
+}
+
+func TestMountStatic(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	admin := New()
+	m.Mount("/admin", admin)
+	admin.Get("/users", func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		if p := URLParams(c); len(p) != 0 {
+			t.Errorf("Expected no URL parameters, got %v", p)
+		}
+		w.Write([]byte(r.URL.Path))
+	})
+
+	r, _ := http.NewRequest("GET", "/admin/users", nil)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "/users" {
+		t.Errorf(`Expected 200 "/users", got %d %q`, w.Code, w.Body.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected mounting a Mux twice to panic")
+		}
+	}()
+	New().Mount("/again", admin)
+}
+
+func TestHandleSubRouterParams(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	sub := New()
+	ch := make(chan map[string]string, 1)
+	m.Handle("/x/:id/*", sub)
+	sub.Get("/x/:id/y", func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		ch <- URLParams(c)
+	})
+	sub.Get("/x/:id/z/:name", func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		ch <- URLParams(c)
+	})
+
+	r, _ := http.NewRequest("GET", "/x/1/y", nil)
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	if p := <-ch; len(p) != 1 || p["id"] != "1" {
+		t.Errorf(`Expected only {"id": "1"}, got %v`, p)
+	}
+
+	r, _ = http.NewRequest("GET", "/x/1/z/carl", nil)
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	if p := <-ch; len(p) != 2 || p["id"] != "1" || p["name"] != "carl" {
+		t.Errorf(`Expected only "id" and "name", got %v`, p)
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	m.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("root"))
+	})
+	mw := func(h http.Handler) http.Handler {
+		return h
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			m.Use(mw)
+			m.Get("/"+strings.Repeat("a", i+1), http.NotFound)
+			m.Abandon(mw)
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		r, _ := http.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		if w.Body.String() != "root" {
+			t.Fatalf(`Expected "root", got %q`, w.Body.String())
+		}
+	}
+}
+
+func TestUseRouted(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	ch := make(chan string, 10)
+
+	m.Use(func(h Handler) Handler {
+		return HandlerFunc(func(c context.Context, w http.ResponseWriter, r *http.Request) {
+			if _, ok := MatchedRoute(c); ok {
+				ch <- "pre:routed"
+			} else {
+				ch <- "pre:unrouted"
+			}
+			h.ServeHTTPC(c, w, r)
+		})
+	})
+	routed := func(h Handler) Handler {
+		return HandlerFunc(func(c context.Context, w http.ResponseWriter, r *http.Request) {
+			ri, _ := MatchedRoute(c)
+			name, _ := URLParam(c, "name")
+			ch <- "post:" + ri.Pattern + ":" + name
+			h.ServeHTTPC(c, w, r)
+		})
+	}
+	m.UseRouted(routed)
+	m.Group("/g", func(g *Group) {
+		g.Use(func(c context.Context, w http.ResponseWriter, r *http.Request, next Handler) {
+			ch <- "group"
+			next.ServeHTTPC(c, w, r)
+		})
+		g.Get("/:name", func(w http.ResponseWriter, r *http.Request) {
+			ch <- "handler"
+		})
+	})
+	m.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		ch <- "notfound"
+	})
+
+	for _, test := range []struct {
+		path     string
+		expected []string
+	}{
+		{"/g/carl", []string{"pre:unrouted", "post:/g/:name:carl", "group", "handler"}},
+		{"/nope", []string{"pre:unrouted", "notfound"}},
+	} {
+		r, _ := http.NewRequest("GET", test.path, nil)
+		m.ServeHTTP(httptest.NewRecorder(), r)
+		for _, e := range test.expected {
+			if a := <-ch; a != e {
+				t.Errorf("Expected %q for %q, got %q", e, test.path, a)
+			}
+		}
+	}
+
+	if err := m.AbandonRouted(routed); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest("GET", "/g/carl", nil)
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	for _, e := range []string{"pre:unrouted", "group", "handler"} {
+		if a := <-ch; a != e {
+			t.Errorf("Expected %q after AbandonRouted, got %q", e, a)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+// +build !race
+
+package web
+
+const raceEnabled = false
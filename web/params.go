@@ -2,6 +2,7 @@ package web
 
 import (
 	"sort"
+	"sync"
 
 	"code.google.com/p/go.net/context"
 )
@@ -13,31 +14,320 @@ const (
 	// The key used to communicate to the NotFound handler what methods would have
 	// been allowed if they'd been provided.
 	validMethodsKey
+	// The key used to remember the path of a request as it was before any
+	// Mux it was routed to stripped part of it (see Mux.Mount).
+	mountPathKey
+	// The key of the route which matched the request.
+	routeKey
+	// The key of the routeCapture installed by CaptureRoute.
+	captureKey
+	// The key under which the contexts of Muxes for which RequestContext
+	// is enabled report it.
+	requestContextKey
 )
 
+/*
+URLParams returns the URL parameters bound by the route that matched the request
+(see the documentation for type Mux), or nil if there are none. The returned map
+may be shared with other callers, and must not be modified.
+
+The parameters of a request are stored in memory which is reused once the
+request has been handled, in order to avoid allocating memory for them on each
+request. A context obtained from a Mux may therefore not be used to retrieve URL
+parameters after the handler has returned: handlers which start goroutines
+needing them should call URLParams (whose map remains valid) beforehand.
+*/
 func URLParams(ctx context.Context) map[string]string {
-	if u, ok := ctx.Value(paramKey).(map[string]string); ok {
+	switch u := ctx.Value(paramKey).(type) {
+	case map[string]string:
+		return u
+	case inheritedParams:
 		return u
+	case *routedContext:
+		return u.urlParams()
+	case *routeParams:
+		return u.urlParams()
 	}
 	return nil
 }
 
+// URLParam returns the value of the named URL parameter, and whether it was
+// bound by the route that matched the request. Unlike URLParams, URLParam does
+// not allocate memory, and it should be preferred when only a few parameters
+// are needed. The same restrictions apply to its use after the handler has
+// returned.
+func URLParam(ctx context.Context, name string) (string, bool) {
+	switch u := ctx.Value(paramKey).(type) {
+	case map[string]string:
+		v, ok := u[name]
+		return v, ok
+	case inheritedParams:
+		v, ok := u[name]
+		return v, ok
+	case *routedContext:
+		return getParam(u.Context, u.keys, u.values, name)
+	case *routeParams:
+		return getParam(u.Context, u.keys, u.values, name)
+	}
+	return "", false
+}
+
+// inheritedParams are the URL parameters bound by the prefix a Mux has been
+// mounted under (see Mux.Mount), which remain visible to the routes of the
+// sub-mux. The parameters of other enclosing routes are not inherited.
+type inheritedParams map[string]string
+
+func inherited(ctx context.Context) inheritedParams {
+	m, _ := ctx.Value(paramKey).(inheritedParams)
+	return m
+}
+
+// withURLParams binds the given URL parameters in the context. Parameters which
+// were bound by the prefix of a mounted Mux remain visible unless they are
+// shadowed by the new ones.
 func withURLParams(ctx context.Context, v map[string]string) context.Context {
+	if rp, ok := ctx.(*routeParams); ok {
+		for k, cv := range v {
+			rp.add(k, cv)
+		}
+		return rp
+	}
+	if parent := inherited(ctx); len(parent) != 0 {
+		merged := make(map[string]string, len(parent)+len(v))
+		for k, pv := range parent {
+			merged[k] = pv
+		}
+		for k, cv := range v {
+			merged[k] = cv
+		}
+		v = merged
+	}
 	return context.WithValue(ctx, paramKey, v)
 }
 
+/*
+routeParams is the context in which a router routes requests. It stores the URL
+parameters bound by patterns in slices which are reused from one request to the
+next, since each routeParams belongs to a pooled cStack: routing a request to a
+pattern with parameters does not need to allocate memory.
+
+Patterns which know about routeParams add their parameters to it directly, and
+return it as the context of the match. Since routes may bind parameters and then
+fail to match (for instance because of their method), the router truncates the
+parameters back to their previous state whenever this happens.
+
+Since its parameters change as the request is routed, a routeParams is not
+handed to handlers: once a route matches, its parameters are copied to the
+routedContext it holds (see router.withRoute), which does not allocate memory
+either.
+*/
+type routeParams struct {
+	context.Context
+	keys, values []string
+	// The map returned by URLParams, built on demand.
+	m map[string]string
+	// Scratch space for the segments of the path, used by the tree router.
+	segs []string
+	// The route which matched the request, once there is one.
+	matched matchedRoute
+	// The context of the handler of that route.
+	routed routedContext
+	// Whether the routeParams has become part of the context of a handler,
+	// in which case it must not be reused.
+	escaped bool
+	// Whether the Mux stores contexts on requests (see Mux.RequestContext).
+	requestContext bool
+}
+
+// reset prepares the routeParams for a new request in the given context, or
+// drops any reference to the last request if c is nil.
+func (p *routeParams) reset(c context.Context) {
+	p.Context = c
+	p.keys = p.keys[:0]
+	p.values = p.values[:0]
+	p.m = nil
+	p.matched = matchedRoute{}
+	p.routed.reset()
+}
+
+// route returns the context in which the handler of the given route, which
+// matched the request, is called.
+func (p *routeParams) route(m matchedRoute) *routedContext {
+	c := &p.routed
+	c.Context = p.Context
+	c.keys = append(c.keys[:0], p.keys...)
+	c.values = append(c.values[:0], p.values...)
+	c.matched = m
+	c.requestContext = p.requestContext
+	return c
+}
+
+// escape freezes the routeParams for use as (part of) the context of the
+// handler of the given route. It must not be modified or reused afterwards.
+func (p *routeParams) escape(m matchedRoute) {
+	p.m = params(p.Context, p.keys, p.values)
+	p.matched = m
+	p.escaped = true
+}
+
+func (p *routeParams) Value(key interface{}) interface{} {
+	if key == paramKey {
+		if len(p.keys) != 0 {
+			return p
+		}
+		if m := inherited(p.Context); m != nil {
+			return m
+		}
+		return nil
+	}
+	if key == routeKey && p.matched.r != nil {
+		return &p.matched
+	}
+	if key == requestContextKey && p.requestContext {
+		return true
+	}
+	return p.Context.Value(key)
+}
+
+func (p *routeParams) add(k, v string) {
+	p.keys = append(p.keys, k)
+	p.values = append(p.values, v)
+	p.m = nil
+}
+
+// mark returns the current state of the parameters, to be passed to truncate.
+// Both methods can be called on a nil routeParams, to make life easier for
+// callers which route outside of a Mux.
+func (p *routeParams) mark() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.keys)
+}
+
+func (p *routeParams) truncate(n int) {
+	if p == nil || n == len(p.keys) {
+		return
+	}
+	p.keys = p.keys[:n]
+	p.values = p.values[:n]
+	p.m = nil
+}
+
+func (p *routeParams) urlParams() map[string]string {
+	if p.m == nil {
+		p.m = params(p.Context, p.keys, p.values)
+	}
+	return p.m
+}
+
+// getParam returns the value of the named parameter among the given ones, or
+// among the parameters inherited from the context.
+func getParam(c context.Context, keys, values []string, name string) (string, bool) {
+	// Later parameters shadow earlier ones.
+	for i := len(keys) - 1; i >= 0; i-- {
+		if keys[i] == name {
+			return values[i], true
+		}
+	}
+	v, ok := inherited(c)[name]
+	return v, ok
+}
+
+// params returns a new map of the given parameters and of the parameters
+// inherited from the context, or nil if there are none.
+func params(c context.Context, keys, values []string) map[string]string {
+	parent := inherited(c)
+	if len(keys) == 0 && len(parent) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(parent)+len(keys))
+	for k, v := range parent {
+		m[k] = v
+	}
+	for i, k := range keys {
+		m[k] = values[i]
+	}
+	return m
+}
+
+/*
+routedContext is the context in which the handler of a route is called. Its URL
+parameters are copied out of the routeParams the request was routed in, so that
+they do not change while the handler runs. Like the routeParams, it is reused
+once the request has been handled.
+
+Since handlers may pass their context to other goroutines, the map returned by
+URLParams is built under a lock, and is never reused.
+*/
+type routedContext struct {
+	context.Context
+	keys, values   []string
+	matched        matchedRoute
+	requestContext bool
+
+	lock sync.Mutex
+	// The map returned by URLParams, built on demand.
+	m map[string]string
+}
+
+func (c *routedContext) reset() {
+	// Contexts kept after their handler returned must not crash.
+	c.Context = bgctx
+	c.keys = c.keys[:0]
+	c.values = c.values[:0]
+	c.matched = matchedRoute{}
+	c.m = nil
+}
+
+func (c *routedContext) Value(key interface{}) interface{} {
+	if key == paramKey {
+		if len(c.keys) != 0 {
+			return c
+		}
+		if m := inherited(c.Context); m != nil {
+			return m
+		}
+		return nil
+	}
+	if key == routeKey {
+		if c.matched.r == nil {
+			return nil
+		}
+		return &c.matched
+	}
+	if key == requestContextKey && c.requestContext {
+		return true
+	}
+	return c.Context.Value(key)
+}
+
+func (c *routedContext) urlParams() map[string]string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.m == nil {
+		c.m = params(c.Context, c.keys, c.values)
+	}
+	return c.m
+}
+
 // ValidMethods can be used in a NotFound handler to get the list of valid methods
 func ValidMethods(ctx context.Context) []string {
 	if ms, ok := ctx.Value(validMethodsKey).(methodSet); ok {
-		var methodsList []string
-		for mname, meth := range validMethodsMap {
-			if ms&methodSet(meth) != 0 {
-				methodsList = append(methodsList, mname)
-			}
-		}
-		sort.Strings(methodsList)
-
-		return methodsList
+		return ms.names()
 	}
 	return nil
 }
+
+// names returns the sorted list of the names of the methods in the set.
+func (ms methodSet) names() []string {
+	var methodsList []string
+	for mname, meth := range getMethods() {
+		if ms&methodSet(meth) != 0 {
+			methodsList = append(methodsList, mname)
+		}
+	}
+	sort.Strings(methodsList)
+
+	return methodsList
+}
@@ -14,6 +14,12 @@ import (
 //
 // Built-in implementations of this interface are used to implement regular
 // expression and string matching.
+//
+// Patterns may also implement fmt.Stringer, in which case their String method
+// should return a description of the requests they match which does not depend
+// on any particular request, like the "/users/:name" of Sinatra-like patterns.
+// It is used to describe routes by Mux.Routes and MatchedRoute, whose results
+// are typically used as labels in logs and metrics.
 type Pattern interface {
 	// In practice, most real-world routes have a string prefix that can be
 	// used to quickly determine if a pattern is an eligible match. The
@@ -4,7 +4,9 @@ import (
 	"net/http"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"code.google.com/p/go.net/context"
 )
@@ -23,6 +25,12 @@ func pt(url string, match bool, params map[string]string) patternTest {
 	}
 }
 
+func pth(url string, header http.Header, match bool, params map[string]string) patternTest {
+	test := pt(url, match, params)
+	test.r.Header = header
+	return test
+}
+
 type patternTest struct {
 	r     *http.Request
 	match bool
@@ -129,7 +137,75 @@ var patternTests = []struct {
 			pt("/a/cat/dog.gif", false, nil),
 		}},
 
+	// String constraint tests
+	{parseStringPattern("/users/:id{int}"),
+		"/users/", []patternTest{
+			pt("/users/123", true, map[string]string{
+				"id": "123",
+			}),
+			pt("/users/-4", true, map[string]string{
+				"id": "-4",
+			}),
+			pt("/users/carl", false, nil),
+			pt("/users/12a", false, nil),
+			pt("/users/", false, nil),
+		}},
+	{parseStringPattern("/files/:slug{[a-z0-9-]+}.:ext"),
+		"/files/", []patternTest{
+			pt("/files/my-file.tar.gz", true, map[string]string{
+				"slug": "my-file",
+				"ext":  "tar.gz",
+			}),
+			pt("/files/My-File.txt", false, nil),
+			pt("/files/my_file.txt", false, nil),
+		}},
+	{parseStringPattern("/years/:year{[0-9]{4}}/:uuid{uuid}"),
+		"/years/", []patternTest{
+			pt("/years/2014/6ba7b810-9dad-11d1-80b4-00c04fd430c8", true,
+				map[string]string{
+					"year": "2014",
+					"uuid": "6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+				}),
+			pt("/years/14/6ba7b810-9dad-11d1-80b4-00c04fd430c8", false, nil),
+			pt("/years/2014/6ba7b810", false, nil),
+		}},
+
+	// Host pattern tests
+	{Host(":tenant.example.com", "/users/:name"),
+		"/users/", []patternTest{
+			pt("http://acme.example.com/users/carl", true,
+				map[string]string{
+					"tenant": "acme",
+					"name":   "carl",
+				}),
+			pt("http://acme.EXAMPLE.com:8000/users/carl", true,
+				map[string]string{
+					"tenant": "acme",
+					"name":   "carl",
+				}),
+			pt("http://example.com/users/carl", false, nil),
+			pt("http://a.b.example.com/users/carl", false, nil),
+			pt("http://acme.example.org/users/carl", false, nil),
+			pt("http://acme.example.com/users/", false, nil),
+		}},
+	{Host("*.example.com", "/"),
+		"/", []patternTest{
+			pt("http://www.example.com/", true, nil),
+			pt("http://www.example.com/hello", false, nil),
+			pt("http://example.com/", false, nil),
+		}},
+
 	// String prefix tests
+	{parseStringPattern("/user/*"),
+		"/user/", []patternTest{
+			pt("/user/", true, map[string]string{
+				"*": "/",
+			}),
+			pt("/user/bob/friends", true, map[string]string{
+				"*": "/bob/friends",
+			}),
+			pt("/user", false, nil),
+		}},
 	{parseStringPattern("/user/:user/*"),
 		"/user/", []patternTest{
 			pt("/user/bob/", true, map[string]string{
@@ -156,6 +232,118 @@ var patternTests = []struct {
 			}),
 			pt("/user/bob/enemies", false, nil),
 		}},
+
+	// Named catch-alls and optional segments
+	{parseStringPattern("/static/*filepath"),
+		"/static/", []patternTest{
+			pt("/static/", true, map[string]string{
+				"filepath": "/",
+			}),
+			pt("/static/css/site.css", true, map[string]string{
+				"filepath": "/css/site.css",
+			}),
+			pt("/static", false, nil),
+		}},
+	{parseStringPattern("/posts(/:page{int})"),
+		"/posts", []patternTest{
+			pt("/posts", true, nil),
+			pt("/posts/2", true, map[string]string{
+				"page": "2",
+			}),
+			pt("/posts/", false, nil),
+			pt("/posts/two", false, nil),
+			pt("/posts/2/3", false, nil),
+		}},
+	{parseStringPattern("/archive(/:year(/:month))"),
+		"/archive", []patternTest{
+			pt("/archive", true, nil),
+			pt("/archive/2014", true, map[string]string{
+				"year": "2014",
+			}),
+			pt("/archive/2014/10", true, map[string]string{
+				"year":  "2014",
+				"month": "10",
+			}),
+			pt("/archive/2014/10/1", false, nil),
+		}},
+	{parseStringPattern("/repos/*path/blob/:ref"),
+		"/repos", []patternTest{
+			pt("/repos/a/blob/master", true, map[string]string{
+				"path": "/a",
+				"ref":  "master",
+			}),
+			pt("/repos/a/b/blob/master", true, map[string]string{
+				"path": "/a/b",
+				"ref":  "master",
+			}),
+			pt("/repos/blob/master", false, nil),
+			pt("/repos//blob/master", false, nil),
+			pt("/repos/a/blob/", false, nil),
+		}},
+	{parseStringPattern("/docs(/:lang{alpha})/*"),
+		"/docs", []patternTest{
+			pt("/docs/en/intro", true, map[string]string{
+				"lang": "en",
+				"*":    "/intro",
+			}),
+			pt("/docs/1/intro", true, map[string]string{
+				"*": "/1/intro",
+			}),
+			pt("/docs/", true, map[string]string{
+				"*": "/",
+			}),
+			pt("/docs", false, nil),
+		}},
+
+	// Request pattern tests
+	{Header("x-debug", "", "/users/:name"),
+		"/users/", []patternTest{
+			pth("/users/carl", http.Header{"X-Debug": {"1"}}, true,
+				map[string]string{"name": "carl"}),
+			pth("/users/carl", http.Header{}, false, nil),
+			pth("/users", http.Header{"X-Debug": {"1"}}, false, nil),
+		}},
+	{Header("X-Version", "2", "/users"),
+		"/users", []patternTest{
+			pth("/users", http.Header{"X-Version": {"1", "2"}}, true, nil),
+			pth("/users", http.Header{"X-Version": {"1"}}, false, nil),
+		}},
+	{Query("format", "json", Query("pretty", "", "/users")),
+		"/users", []patternTest{
+			pt("/users?format=json&pretty", true, nil),
+			pt("/users?pretty=1&format=xml&format=json", true, nil),
+			pt("/users?format=json", false, nil),
+			pt("/users?format=xml&pretty", false, nil),
+		}},
+	{ContentType("application/json", "/users"),
+		"/users", []patternTest{
+			pth("/users", http.Header{"Content-Type": {"application/json"}},
+				true, nil),
+			pth("/users", http.Header{"Content-Type": {"Application/JSON; charset=utf-8"}},
+				true, nil),
+			pth("/users", http.Header{"Content-Type": {"text/plain"}},
+				false, nil),
+			pth("/users", http.Header{}, false, nil),
+		}},
+	{ContentType("text/*; charset=utf-8", "/users"),
+		"/users", []patternTest{
+			pth("/users", http.Header{"Content-Type": {"text/csv; charset=UTF-8"}},
+				true, nil),
+			pth("/users", http.Header{"Content-Type": {"text/csv"}},
+				false, nil),
+		}},
+	{Accept("application/vnd.example.v2+json", "/users"),
+		"/users", []patternTest{
+			pth("/users", http.Header{"Accept": {"application/vnd.example.v2+json"}},
+				true, nil),
+			pth("/users", http.Header{"Accept": {"text/html, application/vnd.example.v2+json;q=0.5"}},
+				true, nil),
+			pth("/users", http.Header{"Accept": {"application/vnd.example.v2+json;q=0"}},
+				false, nil),
+			pth("/users", http.Header{"Accept": {"*/*"}}, false, nil),
+			pth("/users", http.Header{"Accept": {"application/*"}}, false, nil),
+			pth("/users", http.Header{}, false, nil),
+		}},
 }
 
 func TestPatterns(t *testing.T) {
@@ -174,6 +362,30 @@ func TestPatterns(t *testing.T) {
 	}
 }
 
+func TestCatchAllsInTheMiddle(t *testing.T) {
+	t.Parallel()
+
+	// Paths made of many segments must not take long to reject.
+	pat := parseStringPattern("/r/*a/x/:id/raw")
+	path := "/r" + strings.Repeat("/x", 2048)
+	r, _ := http.NewRequest("GET", path, nil)
+	start := time.Now()
+	if _, ok := pat.Match(r, context.Background()); ok {
+		t.Errorf("Expected %q not to match", path)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Matching a %d byte path took %v", len(path), d)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic for a pattern with two catch-alls " +
+				"in its middle")
+		}
+	}()
+	parseStringPattern("/r/*a/x/*b/raw")
+}
+
 func runTest(t *testing.T, p Pattern, test patternTest) {
 	cout, result := p.Match(test.r, test.c)
 	if result != test.match {
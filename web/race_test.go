@@ -0,0 +1,7 @@
+// +build race
+
+package web
+
+// The race detector makes sync.Pool drop items at random, which makes
+// allocation counts meaningless.
+const raceEnabled = true
@@ -0,0 +1,65 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"code.google.com/p/go.net/context"
+)
+
+// redirect tries the variants of the request's path described in
+// Mux.RedirectPaths, and redirects the request to the first that routes, if
+// any. It returns false if the request was not redirected.
+func (rt *router) redirect(rm *routeMachine, c context.Context, w http.ResponseWriter, r *http.Request) bool {
+	rt.lock.Lock()
+	code := rt.redirectCode
+	rt.lock.Unlock()
+	if code == 0 {
+		return false
+	}
+
+	p := r.URL.Path
+	if p == "" || p[0] != '/' {
+		return false
+	}
+	clean := path.Clean(p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	var toggled string
+	if strings.HasSuffix(clean, "/") {
+		toggled = strings.TrimSuffix(clean, "/")
+	} else {
+		toggled = clean + "/"
+	}
+
+	for _, candidate := range []string{clean, toggled} {
+		if candidate == p || candidate == "" {
+			continue
+		}
+
+		r2 := *r
+		u := *r.URL
+		u.Path = candidate
+		r2.URL = &u
+		if _, _, route := rm.route(c, w, &r2); route == nil {
+			continue
+		}
+
+		// If a Mux we were mounted on stripped part of the path, we
+		// need to put it back.
+		if orig, ok := c.Value(mountPathKey).(string); ok &&
+			strings.HasSuffix(orig, p) {
+			u.Path = orig[:len(orig)-len(p)] + candidate
+		}
+		if strings.HasPrefix(u.Path, "//") {
+			return false
+		}
+		target := url.URL{Path: u.Path, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), code)
+		return true
+	}
+	return false
+}
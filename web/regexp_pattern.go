@@ -41,6 +41,112 @@ func (p regexpPattern) match(r *http.Request, c context.Context) (context.Contex
 	return withURLParams(c, urlParams), true
 }
 
+func (p regexpPattern) buildPath(params map[string]string) (string, error) {
+	sRe, err := syntax.Parse(p.re.String(), syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := p.build(&buf, sRe, params); err != nil {
+		return "", err
+	}
+	path := buf.String()
+
+	// The reconstruction above is only a best effort: make sure the regexp
+	// agrees with it.
+	matches := p.re.FindStringSubmatch(path)
+	if matches == nil {
+		return "", fmt.Errorf("parameters %v do not match %v", params,
+			p.re)
+	}
+	for i := 1; i < len(matches); i++ {
+		if v, ok := params[p.names[i]]; ok && v != matches[i] {
+			return "", fmt.Errorf("invalid value %q for parameter %q",
+				v, p.names[i])
+		}
+	}
+	return path, nil
+}
+
+// build walks the parse tree of the pattern's regexp, writing the shortest
+// string it can find that the regexp matches, and substituting parameters for
+// capture groups.
+func (p regexpPattern) build(buf *bytes.Buffer, re *syntax.Regexp, params map[string]string) error {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine,
+		syntax.OpBeginText, syntax.OpEndText, syntax.OpWordBoundary,
+		syntax.OpNoWordBoundary:
+		// Zero-width: nothing to write.
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			buf.WriteRune(r)
+		}
+	case syntax.OpCharClass:
+		if len(re.Rune) != 2 || re.Rune[0] != re.Rune[1] {
+			return fmt.Errorf("cannot reverse character class %v", re)
+		}
+		buf.WriteRune(re.Rune[0])
+	case syntax.OpCapture:
+		name := p.names[re.Cap]
+		v, ok := params[name]
+		if !ok {
+			return fmt.Errorf("missing parameter %q", name)
+		}
+		buf.WriteString(v)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if err := p.build(buf, sub, params); err != nil {
+				return err
+			}
+		}
+	case syntax.OpQuest, syntax.OpStar:
+		// Prefer the empty repetition, unless doing so would lose
+		// parameters we were explicitly given.
+		if p.usesParams(re.Sub[0], params) {
+			return p.build(buf, re.Sub[0], params)
+		}
+	case syntax.OpPlus:
+		return p.build(buf, re.Sub[0], params)
+	case syntax.OpRepeat:
+		n := re.Min
+		if n == 0 && p.usesParams(re.Sub[0], params) {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			if err := p.build(buf, re.Sub[0], params); err != nil {
+				return err
+			}
+		}
+	case syntax.OpAlternate:
+		var err error
+		for _, sub := range re.Sub {
+			var alt bytes.Buffer
+			if err = p.build(&alt, sub, params); err == nil {
+				buf.Write(alt.Bytes())
+				return nil
+			}
+		}
+		return err
+	default:
+		return fmt.Errorf("cannot reverse %v", re)
+	}
+	return nil
+}
+
+func (p regexpPattern) usesParams(re *syntax.Regexp, params map[string]string) bool {
+	if re.Op == syntax.OpCapture {
+		if _, ok := params[p.names[re.Cap]]; ok {
+			return true
+		}
+	}
+	for _, sub := range re.Sub {
+		if p.usesParams(sub, params) {
+			return true
+		}
+	}
+	return false
+}
+
 func (p regexpPattern) String() string {
 	return fmt.Sprintf("regexpPattern(%v)", p.re)
 }
@@ -0,0 +1,188 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"code.google.com/p/go.net/context"
+)
+
+// requestPattern is a Pattern which matches a property of the request other
+// than its path before delegating to another Pattern for its path.
+type requestPattern struct {
+	desc  string
+	match func(r *http.Request) bool
+	path  Pattern
+}
+
+func (p requestPattern) Prefix() string {
+	return p.path.Prefix()
+}
+
+func (p requestPattern) Match(r *http.Request, c context.Context) (context.Context, bool) {
+	if !p.match(r) {
+		return c, false
+	}
+	return p.path.Match(r, c)
+}
+
+func (p requestPattern) buildPath(params map[string]string) (string, error) {
+	b, ok := p.path.(urlBuilder)
+	if !ok {
+		return "", fmt.Errorf("path pattern of type %T cannot be "+
+			"reversed", p.path)
+	}
+	return b.buildPath(params)
+}
+
+func (p requestPattern) String() string {
+	return patternString(p.path) + " [" + p.desc + "]"
+}
+
+/*
+Header returns a Pattern which matches requests whose path matches the given
+path pattern, and which have a header with the given name and value. If value is
+empty, the header only needs to be present. The path pattern may be of any of
+the types accepted by the route-adding functions on Mux (see the documentation
+for type Mux), including other patterns returned by this package, so that
+patterns can be combined:
+
+	m.Get(web.Header("X-Debug", "", web.Query("format", "json", "/users")), h)
+*/
+func Header(name, value string, path interface{}) Pattern {
+	name = http.CanonicalHeaderKey(name)
+	return requestPattern{
+		desc: name + ": " + value,
+		match: func(r *http.Request) bool {
+			return hasValue(r.Header[name], value)
+		},
+		path: parsePattern(path),
+	}
+}
+
+// Query returns a Pattern which matches requests whose path matches the given
+// path pattern, and whose query string has a parameter with the given name and
+// value. If value is empty, the parameter only needs to be present. See Header.
+func Query(name, value string, path interface{}) Pattern {
+	return requestPattern{
+		desc: "?" + name + "=" + value,
+		match: func(r *http.Request) bool {
+			return hasValue(r.URL.Query()[name], value)
+		},
+		path: parsePattern(path),
+	}
+}
+
+func hasValue(values []string, value string) bool {
+	if value == "" {
+		return len(values) != 0
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ContentType returns a Pattern which matches requests whose path matches the
+given path pattern, and whose body has the given media type, according to their
+Content-Type header. The media type is compared without regard to case, and a
+subtype of "*" (as in "image/*") matches any subtype. If the media type has
+parameters, the request's Content-Type must have the same values for each of
+them, but may have others. See Header.
+*/
+func ContentType(mediaType string, path interface{}) Pattern {
+	want := parseMediaRange(mediaType)
+	return requestPattern{
+		desc: "Content-Type: " + mediaType,
+		match: func(r *http.Request) bool {
+			ct := r.Header.Get("Content-Type")
+			return ct != "" && want.matches(parseMediaRange(ct))
+		},
+		path: parsePattern(path),
+	}
+}
+
+// Accept returns a Pattern which matches requests whose path matches the given
+// path pattern, and whose Accept header explicitly lists the given media type
+// with a non-zero quality. Parameters of the media type are compared as with
+// ContentType.
+//
+// Wildcard media ranges like "*/*" or "application/*" are not taken into
+// account, so that clients which accept anything are routed as if the route did
+// not exist. This makes Accept well suited to API versioning:
+//
+//	m.Get(web.Accept("application/vnd.example.v2+json", "/users"), usersV2)
+//	m.Get("/users", usersV1)
+//
+// See Header.
+func Accept(mediaType string, path interface{}) Pattern {
+	want := parseMediaRange(mediaType)
+	if want.subtype == "*" {
+		log.Panicf("web: Accept requires a complete media type, not %q",
+			mediaType)
+	}
+	return requestPattern{
+		desc: "Accept: " + mediaType,
+		match: func(r *http.Request) bool {
+			for _, h := range r.Header["Accept"] {
+				for _, s := range strings.Split(h, ",") {
+					if mr := parseMediaRange(s); mr.q > 0 && want.matches(mr) {
+						return true
+					}
+				}
+			}
+			return false
+		},
+		path: parsePattern(path),
+	}
+}
+
+type mediaRange struct {
+	typ, subtype string
+	params       map[string]string
+	q            float64
+}
+
+// parseMediaRange parses a media type, or one of the media ranges of an Accept
+// header. Malformed media types are returned with an empty type, which matches
+// nothing.
+func parseMediaRange(s string) mediaRange {
+	mt, params, err := mime.ParseMediaType(strings.TrimSpace(s))
+	if err != nil {
+		return mediaRange{}
+	}
+	mr := mediaRange{params: params, q: 1}
+	i := strings.IndexByte(mt, '/')
+	if i == -1 {
+		return mediaRange{}
+	}
+	mr.typ, mr.subtype = mt[:i], mt[i+1:]
+	if q, ok := params["q"]; ok {
+		if mr.q, err = strconv.ParseFloat(q, 64); err != nil {
+			mr.q = 0
+		}
+		delete(params, "q")
+	}
+	return mr
+}
+
+// matches reports whether other has the media type m describes.
+func (m mediaRange) matches(other mediaRange) bool {
+	if m.typ == "" || m.typ != other.typ ||
+		m.subtype != "*" && m.subtype != other.subtype {
+		return false
+	}
+	for k, v := range m.params {
+		if ov, ok := other.params[k]; !ok || !strings.EqualFold(ov, v) {
+			return false
+		}
+	}
+	return true
+}
@@ -27,11 +27,19 @@ const (
 	// methods. This constant pretty much only exists for the sake of mALL.
 	mIDK
 
-	mALL method = mCONNECT | mDELETE | mGET | mHEAD | mOPTIONS | mPATCH |
-		mPOST | mPUT | mTRACE | mIDK
+	// mALL also includes the bits of any method registered with
+	// RegisterMethod.
+	mALL method = ^method(0)
 )
 
-var validMethodsMap = map[string]method{
+// The bit to be given to the next method registered with RegisterMethod.
+var nextMethod = mIDK << 1
+var methodsLock sync.Mutex
+
+// The bits of the methods, by name. The map is never modified: registering a
+// method replaces it with a copy (with methodsLock held), so that requests can be
+// routed without taking the lock. Use getMethods to read it.
+var validMethodsMap = &map[string]method{
 	"CONNECT": mCONNECT,
 	"DELETE":  mDELETE,
 	"GET":     mGET,
@@ -48,18 +56,43 @@ type route struct {
 	method  method
 	pattern Pattern
 	handler Handler
+	// The handler as it was originally given to us, before parseHandler
+	// wrapped it. Only used for introspection.
+	raw interface{}
+	// The name the route was registered under, if any.
+	name string
+	// Metadata attached to the route with Route.Set. The map is never
+	// modified once the route has been added to the router.
+	meta map[interface{}]interface{}
+	// The route's own middleware stack, if it has one. The handler is then
+	// the bottom of this stack.
+	ms *mStack
 }
 
 type router struct {
 	lock     sync.Mutex
-	routes   []route
+	routes   []*route
+	names    map[string]*route
 	notFound Handler
-	machine  *routeMachine
+	// If non-nil, the handler for requests whose path matched a route but
+	// whose method did not.
+	methodNotAllowed Handler
+	// If non-zero, the status code used to redirect requests which could
+	// be routed if their path was cleaned up.
+	redirectCode int
+	// Whether to route requests with a tree instead of the bytecode.
+	treeRouting bool
+	machine     *routeMachine
+	mount       *mountPoint
+	// The Mux's post-routing middleware stack (see Mux.UseRouted), which
+	// wraps the handlers of matched routes.
+	post *mStack
 }
 
 type netHTTPWrap func(w http.ResponseWriter, r *http.Request)
 
 func (h netHTTPWrap) ServeHTTPC(c context.Context, w http.ResponseWriter, r *http.Request) {
+	r, _ = withContext(c, r, false)
 	h(w, r)
 }
 
@@ -71,19 +104,67 @@ func parseHandler(h interface{}) Handler {
 		return netHTTPWrap(f.ServeHTTP)
 	case func(c context.Context, w http.ResponseWriter, r *http.Request):
 		return HandlerFunc(f)
+	case func(c context.Context, w http.ResponseWriter, r *http.Request) error:
+		return ErrorHandlerFunc(f)
 	case func(w http.ResponseWriter, r *http.Request):
 		return netHTTPWrap(f)
 	default:
 		log.Panicf("Unknown handler type %T. Expected a web.Handler, "+
 			"a http.Handler, or a function with signature func(context.Context, "+
-			"http.ResponseWriter, *http.Request) or "+
+			"http.ResponseWriter, *http.Request), func(context.Context, "+
+			"http.ResponseWriter, *http.Request) error or "+
 			"func(http.ResponseWriter, *http.Request)", h)
 	}
 	panic("log.Fatalf does not return")
 }
 
+var defaultNotFound = parseHandler(http.NotFound)
+
+/*
+RegisterMethod registers an additional HTTP method (e.g., one of the WebDAV
+methods, like "PROPFIND" or "MKCOL"), so that routes can be added for it with
+Mux.Method. Requests for unregistered methods can only be routed by routes
+added with Mux.Handle.
+
+Registered methods are reported by ValidMethods (and therefore by
+middleware.AutomaticOptions) like any of the standard methods. Registering a
+method more than once has no effect. Method names are case-sensitive.
+
+Methods are registered globally, for every Mux. They may be registered while
+requests are being served, but are best registered from an init function, before
+routes use them. A limited number of methods (at least 20) can be registered:
+RegisterMethod panics if no more can be.
+*/
+func RegisterMethod(name string) {
+	methodFor(name)
+}
+
+// methodFor returns the bit of the given method, registering it if necessary.
+func methodFor(name string) method {
+	methodsLock.Lock()
+	defer methodsLock.Unlock()
+
+	methods := *validMethodsMap
+	if m, ok := methods[name]; ok {
+		return m
+	}
+	m := nextMethod
+	if m <= 0 {
+		log.Panicf("web: cannot register HTTP method %q: too many "+
+			"methods have been registered", name)
+	}
+	newMethods := make(map[string]method, len(methods)+1)
+	for k, v := range methods {
+		newMethods[k] = v
+	}
+	newMethods[name] = m
+	setMethods(newMethods)
+	nextMethod <<= 1
+	return m
+}
+
 func httpMethod(mname string) method {
-	if method, ok := validMethodsMap[mname]; ok {
+	if method, ok := getMethods()[mname]; ok {
 		return method
 	}
 	return mIDK
@@ -92,9 +173,11 @@ func httpMethod(mname string) method {
 func (rt *router) compile() *routeMachine {
 	rt.lock.Lock()
 	defer rt.lock.Unlock()
-	sm := routeMachine{
-		sm:     compile(rt.routes),
-		routes: rt.routes,
+	sm := routeMachine{routes: rt.routes}
+	if rt.treeRouting {
+		sm.tree = buildTree(rt.routes)
+	} else {
+		sm.sm = compile(rt.routes)
 	}
 	rt.setMachine(&sm)
 	return &sm
@@ -108,22 +191,46 @@ func (rt *router) route(c context.Context, w http.ResponseWriter, r *http.Reques
 
 	methods, rc, route := rm.route(c, w, r)
 	if route != nil {
-		route.handler.ServeHTTPC(rc, w, r)
+		rc = rt.withRoute(c, rc, route)
+		if rt.post != nil && len(rt.post.getPool().stack) != 0 {
+			rt.post.ServeHTTPC(rc, w, r)
+		} else {
+			route.handler.ServeHTTPC(rc, w, r)
+		}
+		return
+	}
+
+	if rt.redirect(rm, c, w, r) {
 		return
 	}
 
-	if methods != 0 {
+	// The methods are only exposed to the handlers which need them: the
+	// NotFound handler of OPTIONS requests, and the MethodNotAllowed
+	// handler.
+	if methods != 0 && r.Method == "OPTIONS" {
 		c = context.WithValue(c, validMethodsKey, methods)
+	} else if methods != 0 {
+		if h := rt.getMethodNotAllowed(); h != nil {
+			if _, ok := rt.getNotFound().(OptionsHandlerFunc); ok {
+				methods |= methodSet(mOPTIONS)
+			}
+			c = context.WithValue(c, validMethodsKey, methods)
+			w.Header().Set("Allow", strings.Join(methods.names(), ", "))
+			h.ServeHTTPC(c, w, r)
+			return
+		}
 	}
 
-	rt.notFound.ServeHTTPC(c, w, r)
+	rt.getNotFound().ServeHTTPC(c, w, r)
 }
 
-func (rt *router) handleUntyped(p interface{}, m method, h interface{}) {
-	rt.handle(parsePattern(p), m, parseHandler(h))
+func (rt *router) handleUntyped(p interface{}, m method, h interface{}) *Route {
+	return rt.handle(parsePattern(p), m, parseHandler(h), h, nil)
 }
 
-func (rt *router) handle(p Pattern, m method, h Handler) {
+// handle adds a route for the given handler. If ms is non-nil, it is the route's
+// middleware stack, at the bottom of which h must be.
+func (rt *router) handle(p Pattern, m method, h Handler, raw interface{}, ms *mStack) *Route {
 	rt.lock.Lock()
 	defer rt.lock.Unlock()
 
@@ -139,16 +246,23 @@ func (rt *router) handle(p Pattern, m method, h Handler) {
 		}
 	}
 
-	newRoutes := make([]route, len(rt.routes)+1)
-	copy(newRoutes, rt.routes[:i])
-	newRoutes[i] = route{
+	r := &route{
 		prefix:  pp,
 		method:  m,
 		pattern: p,
 		handler: h,
+		raw:     raw,
+		ms:      ms,
 	}
+	if ms != nil {
+		r.handler = ms
+	}
+	newRoutes := make([]*route, len(rt.routes)+1)
+	copy(newRoutes, rt.routes[:i])
+	newRoutes[i] = r
 	copy(newRoutes[i+1:], rt.routes[i:])
 
 	rt.setMachine(nil)
 	rt.routes = newRoutes
+	return &Route{rt: rt, r: r}
 }
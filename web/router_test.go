@@ -1,10 +1,12 @@
 package web
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -300,3 +302,634 @@ func TestValidMethods(t *testing.T) {
 		}
 	}
 }
+
+func TestRoutes(t *testing.T) {
+	t.Parallel()
+	m := New()
+	h := testHandler(make(chan string))
+
+	m.Get("/hello/:name", h).Name("hello")
+	m.Post("/hello/carl", h)
+	m.Handle("/admin/*", h)
+	m.Delete(regexp.MustCompile(`^/ip/(?P<ip>[0-9.]+)$`), h)
+	m.Put(testPattern{}, h)
+
+	expected := []RouteInfo{
+		{[]string{"GET", "HEAD"}, "/hello/:name", h, "hello"},
+		{[]string{"POST"}, "/hello/carl", h, ""},
+		{nil, "/admin/*", h, ""},
+		{[]string{"DELETE"}, `^/ip/(?P<ip>[0-9.]+)$`, h, ""},
+		{[]string{"PUT"}, "web.testPattern", h, ""},
+	}
+	routes := m.Routes()
+	if len(routes) != len(expected) {
+		t.Fatalf("Expected %d routes, got %d", len(expected), len(routes))
+	}
+	for _, e := range expected {
+		found := false
+		for _, r := range routes {
+			if reflect.DeepEqual(e, r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected to find route %v in %v", e, routes)
+		}
+	}
+}
+
+var urlTests = []struct {
+	name   string
+	params map[string]string
+	url    string
+}{
+	{"static", nil, "/hello"},
+	{"user", map[string]string{"name": "carl"}, "/users/carl"},
+	{"user", map[string]string{"name": "carl", "extra": "x"}, "/users/carl"},
+	{"user", map[string]string{"name": "a b"}, "/users/a%20b"},
+	{"user", map[string]string{"name": "a/b"}, ""},
+	{"user", map[string]string{"name": ""}, ""},
+	{"user", nil, ""},
+	{"file", map[string]string{"b": "cat", "c": "tar.gz"}, "/a/cat.tar.gz"},
+	{"file", map[string]string{"b": "cat.tar", "c": "gz"}, ""},
+	{"id", map[string]string{"id": "42"}, "/ids/42"},
+	{"id", map[string]string{"id": "carl"}, ""},
+	{"files", map[string]string{"user": "bob", "*": "/friends/123"},
+		"/user/bob/friends/123"},
+	{"files", map[string]string{"user": "bob", "*": "friends"}, ""},
+	{"files", map[string]string{"user": "bob"}, ""},
+	{"static-files", map[string]string{"filepath": "/css/site.css"},
+		"/static/css/site.css"},
+	{"static-files", map[string]string{"*": "/css/site.css"}, ""},
+	{"archive", nil, "/archive"},
+	{"archive", map[string]string{"year": "2014"}, "/archive/2014"},
+	{"archive", map[string]string{"year": "2014", "month": "10"},
+		"/archive/2014/10"},
+	{"archive", map[string]string{"month": "10"}, "/archive"},
+	{"blob", map[string]string{"path": "/a/b", "ref": "master"},
+		"/repos/a/b/blob/master"},
+	{"blob", map[string]string{"path": "/", "ref": "master"}, ""},
+	{"combined", map[string]string{"name": "carl"}, "/v/carl"},
+	{"ab", map[string]string{"a": "1", "b": "2"}, "/a1/b2"},
+	{"ab", map[string]string{"a": "1", "b": "x"}, ""},
+	{"ab", map[string]string{"a": "1"}, ""},
+	{"unnamed", map[string]string{"$1": "world"}, "/hello/world"},
+	{"optional", nil, "/greet"},
+	{"optional", map[string]string{"who": "carl"}, "/greet/carl"},
+	{"custom", nil, ""},
+	{"nope", nil, ""},
+}
+
+func TestURL(t *testing.T) {
+	t.Parallel()
+	m := New()
+
+	m.Get("/hello", http.NotFound).Name("static")
+	m.Get("/users/:name", http.NotFound).Name("user")
+	m.Get("/a/:b.:c", http.NotFound).Name("file")
+	m.Get("/ids/:id{int}", http.NotFound).Name("id")
+	m.Get("/user/:user/*", http.NotFound).Name("files")
+	m.Get("/static/*filepath", http.NotFound).Name("static-files")
+	m.Get("/archive(/:year(/:month))", http.NotFound).Name("archive")
+	m.Get("/repos/*path/blob/:ref", http.NotFound).Name("blob")
+	m.Get(Accept("application/json", Header("X-Version", "2", "/v/:name")), http.NotFound).Name("combined")
+	m.Get(regexp.MustCompile(`^/a(?P<a>\d+)/b(?P<b>\d+)/?$`), http.NotFound).Name("ab")
+	m.Get(regexp.MustCompile(`^/hello/([a-z]+)$`), http.NotFound).Name("unnamed")
+	m.Get(regexp.MustCompile(`^/greet(?:/(?P<who>[a-z]+))?$`), http.NotFound).Name("optional")
+	m.Get(testPattern{}, http.NotFound).Name("custom")
+
+	for _, test := range urlTests {
+		url, err := m.URL(test.name, test.params)
+		if test.url == "" {
+			if err == nil {
+				t.Errorf("Expected an error for %q with %v, got %q",
+					test.name, test.params, url)
+			}
+		} else if err != nil {
+			t.Errorf("Unexpected error for %q with %v: %v", test.name,
+				test.params, err)
+		} else if url != test.url {
+			t.Errorf("Expected %q for %q with %v, got %q", test.url,
+				test.name, test.params, url)
+		}
+	}
+}
+
+func TestDuplicateName(t *testing.T) {
+	t.Parallel()
+	m := New()
+	m.Get("/a", http.NotFound).Name("a")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic when reusing a route name")
+		}
+	}()
+	m.Get("/b", http.NotFound).Name("a")
+}
+
+func TestConstraintFallthrough(t *testing.T) {
+	t.Parallel()
+	m := New()
+	ch := make(chan string, 1)
+
+	m.Get("/users/:id{int}", chHandler(ch, "id"))
+	m.Get("/users/:name", chHandler(ch, "name"))
+	m.NotFound(chHandler(ch, "404"))
+
+	for path, expected := range map[string]string{
+		"/users/123":  "id",
+		"/users/carl": "name",
+		"/users/":     "404",
+	} {
+		r, _ := http.NewRequest("GET", path, nil)
+		m.ServeHTTP(httptest.NewRecorder(), r)
+		if actual := <-ch; actual != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, path,
+				actual)
+		}
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	t.Parallel()
+	m := New()
+	m.Get("/hello/:name", http.NotFound)
+	m.Post("/hello/carl", http.NotFound)
+	m.NotFound(func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		if methods := ValidMethods(c); r.Method != "OPTIONS" && methods != nil {
+			t.Errorf("Expected no valid methods for %s, got %v",
+				r.Method, methods)
+		}
+		http.NotFound(w, r)
+	})
+
+	// Without a handler, we 404 as usual
+	r, _ := http.NewRequest("PUT", "/hello/carl", nil)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+
+	m.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Nope", http.StatusMethodNotAllowed)
+	})
+
+	r, _ = http.NewRequest("PUT", "/hello/carl", nil)
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, POST" {
+		t.Errorf(`Expected Allow to be "GET, HEAD, POST", got %q`, allow)
+	}
+
+	// Paths no route matches are still 404s
+	r, _ = http.NewRequest("PUT", "/goodbye/carl", nil)
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+
+	// And so are OPTIONS requests
+	r, _ = http.NewRequest("OPTIONS", "/hello/carl", nil)
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+}
+
+var redirectTable = []struct {
+	path, location string
+}{
+	{"/users/carl", ""},
+	{"/users/carl/", "/users/carl"},
+	{"/users//carl", "/users/carl"},
+	{"/users/./carl/?q=1", "/users/carl?q=1"},
+	{"/admin", "/admin/"},
+	{"/admin/a/../", "/admin/"},
+	{"/sub/x/", "/sub/x"},
+	{"/nope/", ""},
+}
+
+func TestRedirectPaths(t *testing.T) {
+	t.Parallel()
+	m := New()
+	m.Get("/users/:name", http.NotFound)
+	m.Get("/admin/", http.NotFound)
+	sub := New()
+	sub.Get("/x", http.NotFound)
+	m.Mount("/sub", sub)
+
+	m.RedirectPaths(http.StatusMovedPermanently)
+	sub.RedirectPaths(http.StatusMovedPermanently)
+
+	for _, test := range redirectTable {
+		r, _ := http.NewRequest("GET", test.path, nil)
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		if test.location == "" {
+			if w.Code != http.StatusNotFound {
+				t.Errorf("Expected 404 for %q, got %d", test.path,
+					w.Code)
+			}
+			continue
+		}
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("Expected 301 for %q, got %d", test.path, w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != test.location {
+			t.Errorf("Expected %q to redirect to %q, got %q",
+				test.path, test.location, loc)
+		}
+	}
+}
+
+func TestCustomMethods(t *testing.T) {
+	t.Parallel()
+	m := New()
+	ch := make(chan string, 1)
+
+	// Methods may be registered while requests are being served.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		RegisterMethod("LOCK")
+		RegisterMethod("UNLOCK")
+	}()
+	defer func() { <-done }()
+
+	RegisterMethod("MKCOL")
+	m.Method("PROPFIND", "/dav/*", chHandler(ch, "propfind"))
+	m.Method("MKCOL", "/dav/*", chHandler(ch, "mkcol"))
+	m.Get("/dav/*", chHandler(ch, "get"))
+	m.Handle("/*", chHandler(ch, "any"))
+
+	for _, test := range []struct {
+		method, path, expected string
+	}{
+		{"PROPFIND", "/dav/a", "propfind"},
+		{"MKCOL", "/dav/a", "mkcol"},
+		{"GET", "/dav/a", "get"},
+		{"PROPFIND", "/b", "any"},
+		{"COPY", "/dav/a", "any"},
+	} {
+		r, _ := http.NewRequest(test.method, test.path, nil)
+		m.ServeHTTP(httptest.NewRecorder(), r)
+		if actual := <-ch; actual != test.expected {
+			t.Errorf("Expected %q for %s %s, got %q", test.expected,
+				test.method, test.path, actual)
+		}
+	}
+
+	m = New()
+	m.Method("PROPFIND", "/dav/*", http.NotFound)
+	m.Get("/dav/*", http.NotFound)
+	m.NotFound(func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		ch <- strings.Join(ValidMethods(c), ",")
+	})
+	r, _ := http.NewRequest("OPTIONS", "/dav/a", nil)
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	if actual := <-ch; actual != "GET,HEAD,PROPFIND" {
+		t.Errorf(`Expected "GET,HEAD,PROPFIND", got %q`, actual)
+	}
+}
+
+var treeRoutingRoutes = []struct {
+	method, pattern string
+}{
+	{"GET", "/"},
+	{"GET", "/users"},
+	{"GET", "/users/new"},
+	{"GET", "/users/:id{int}"},
+	{"GET", "/users/:name"},
+	{"PUT", "/users/:name"},
+	{"GET", "/users/:name/files/*"},
+	{"GET", "/:section/about"},
+	{"GET", "/files/:name.:ext"},
+	{"GET", "/files/*"},
+	{"GET", "/static/*"},
+	{"GET", "/assets/*file"},
+	{"GET", "/posts(/:page)"},
+}
+
+var treeRoutingTests = []struct {
+	method, path, expected string
+}{
+	{"GET", "/", "/ map[]"},
+	{"GET", "/users", "/users map[]"},
+	{"GET", "/users/", "404"},
+	{"GET", "/users/new", "/users/new map[]"},
+	{"GET", "/users/123", "/users/:id{int} map[id:123]"},
+	{"GET", "/users/carl", "/users/:name map[name:carl]"},
+	{"PUT", "/users/123", "/users/:name map[name:123]"},
+	{"POST", "/users/carl", "404"},
+	{"OPTIONS", "/users/carl", "404 [GET PUT]"},
+	{"GET", "/users/carl/files", "404"},
+	{"GET", "/users/carl/files/", "/users/:name/files/* map[*:/ name:carl]"},
+	{"GET", "/users/carl/files/a/b", "/users/:name/files/* map[*:/a/b name:carl]"},
+	{"GET", "/help/about", "/:section/about map[section:help]"},
+	{"GET", "/files/a.txt", "/files/:name.:ext map[ext:txt name:a]"},
+	{"GET", "/files/a", "/files/* map[*:/a]"},
+	{"GET", "/static/", "/static/* map[*:/]"},
+	{"GET", "/static", "404"},
+	{"GET", "/assets/js/app.js", "/assets/*file map[file:/js/app.js]"},
+	{"GET", "/posts", "/posts(/:page) map[]"},
+	{"GET", "/posts/2", "/posts(/:page) map[page:2]"},
+	{"GET", "//users", "404"},
+	{"GET", "", "404"},
+}
+
+func TestTreeRouting(t *testing.T) {
+	t.Parallel()
+
+	for _, tree := range []bool{false, true} {
+		m := New()
+		m.TreeRouting(tree)
+		ch := make(chan string, 1)
+		for _, rt := range treeRoutingRoutes {
+			s := rt.pattern
+			m.Method(rt.method, s, func(c context.Context, w http.ResponseWriter, r *http.Request) {
+				ch <- fmt.Sprintf("%s %v", s, URLParams(c))
+			})
+		}
+		m.NotFound(func(c context.Context, w http.ResponseWriter, r *http.Request) {
+			if methods := ValidMethods(c); methods != nil {
+				ch <- fmt.Sprintf("404 %v", methods)
+			} else {
+				ch <- "404"
+			}
+		})
+
+		for _, test := range treeRoutingTests {
+			r, _ := http.NewRequest(test.method, "/", nil)
+			r.URL.Path = test.path
+			m.ServeHTTP(httptest.NewRecorder(), r)
+			if actual := <-ch; actual != test.expected {
+				t.Errorf("With tree routing %v, expected %q for %s "+
+					"%q, got %q", tree, test.expected, test.method,
+					test.path, actual)
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	m := New()
+
+	m.Get("/users/:name", http.NotFound)
+	m.Get("/users/new", http.NotFound)
+	m.Post("/users/new", http.NotFound)
+	m.Get("/posts/:id{int}", http.NotFound)
+	m.Get("/posts/:slug", http.NotFound)
+	m.Get("/posts/latest", http.NotFound)
+	m.Get("/:section/about", http.NotFound)
+	m.Handle("/static/*", http.NotFound)
+	m.Get("/static/css/:file", http.NotFound)
+	m.Get(regexp.MustCompile(`^/static/js/(?P<file>.+)$`), http.NotFound)
+	m.Get(regexp.MustCompile(`^/feed$`), http.NotFound)
+	m.Get("/feed/", http.NotFound)
+	m.Get(regexp.MustCompile(`^/feed$`), http.NotFound)
+	m.Get("/files/:id{int}", http.NotFound)
+	m.Get("/files/:name{alpha}", http.NotFound)
+
+	var actual []string
+	for _, c := range m.Validate() {
+		actual = append(actual, c.String())
+	}
+	// Routes are considered (and therefore reported) in the order of the
+	// router, which only preserves the order in which they were added for
+	// routes sharing a prefix.
+	expected := []string{
+		`route GET,HEAD "/posts/latest": shadowed by ["/posts/:slug"]`,
+		`route GET,HEAD "/users/new": shadowed by ["/users/:name"]`,
+		`route GET,HEAD "/:section/about": overlap by ["/posts/:slug"]`,
+		`route GET,HEAD "/:section/about": overlap by ["/users/:name"]`,
+		`route GET,HEAD "^/feed$": shadowed by ["^/feed$"]`,
+		`route GET,HEAD "/files/:name{alpha}": overlap by ["/:section/about"]`,
+		`route GET,HEAD "/static/*": overlap by ["/:section/about"]`,
+		`route GET,HEAD "/static/css/:file": shadowed by ["/static/*"]`,
+		`route GET,HEAD "^/static/js/(?P<file>.+)$": shadowed by ["/static/*"]`,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected conflicts:\n%s\ngot:\n%s",
+			strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func TestRoutedParams(t *testing.T) {
+	t.Parallel()
+	m := New()
+	ch := make(chan map[string]string, 1)
+
+	m.Post("/:first/:second", http.NotFound)
+	m.Get("/:one/:two", func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		v, ok := URLParam(c, "one")
+		if !ok || v != "a" {
+			t.Errorf("Expected URLParam to return %q, got %q", "a", v)
+		}
+		if _, ok := URLParam(c, "first"); ok {
+			t.Errorf("Expected the POST route's parameters to be gone")
+		}
+		ch <- URLParams(c)
+	})
+
+	for _, tree := range []bool{false, true} {
+		m.TreeRouting(tree)
+		r, _ := http.NewRequest("GET", "/a/b", nil)
+		m.ServeHTTP(httptest.NewRecorder(), r)
+		expected := map[string]string{"one": "a", "two": "b"}
+		if params := <-ch; !reflect.DeepEqual(params, expected) {
+			t.Errorf("With tree routing %v, expected %v, got %v",
+				tree, expected, params)
+		}
+	}
+}
+
+type metaKey string
+
+func TestRouteMetadata(t *testing.T) {
+	t.Parallel()
+	m := New()
+	ch := make(chan string, 10)
+
+	mw := func(name string) func(Handler) Handler {
+		return func(h Handler) Handler {
+			return HandlerFunc(func(c context.Context, w http.ResponseWriter, r *http.Request) {
+				scope, _ := RouteValue(c, metaKey("scope")).(string)
+				ch <- name + ":" + scope
+				h.ServeHTTPC(c, w, r)
+			})
+		}
+	}
+	handler := func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		ch <- "handler:" + fmt.Sprint(RouteValue(c, metaKey("timeout")))
+	}
+
+	m.Get("/public", handler)
+	m.Get("/admin", handler).Set(metaKey("scope"), "admin").
+		Set(metaKey("timeout"), 5).Use(mw("route"))
+	m.Group("/g", func(g *Group) {
+		g.Use(mw("group"))
+		g.Get("/a", handler).Use(mw("route")).Set(metaKey("scope"), "g")
+	})
+
+	for path, expected := range map[string][]string{
+		"/public": {"handler:<nil>"},
+		"/admin":  {"route:admin", "handler:5"},
+		"/g/a":    {"group:g", "route:g", "handler:<nil>"},
+	} {
+		r, _ := http.NewRequest("GET", path, nil)
+		m.ServeHTTP(httptest.NewRecorder(), r)
+		var actual []string
+		for range expected {
+			actual = append(actual, <-ch)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected %v for %q, got %v", expected, path,
+				actual)
+		}
+		select {
+		case s := <-ch:
+			t.Errorf("Unexpected %q for %q", s, path)
+		default:
+		}
+	}
+}
+
+type idPattern struct{}
+
+func (idPattern) Prefix() string { return "/id/" }
+
+func (idPattern) Match(r *http.Request, c context.Context) (context.Context, bool) {
+	return c, strings.HasPrefix(r.URL.Path, "/id/")
+}
+
+func (idPattern) String() string { return "/id/{id}" }
+
+func TestMatchedRoute(t *testing.T) {
+	t.Parallel()
+	m := New()
+	ch := make(chan string, 1)
+
+	handler := func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		ri, ok := MatchedRoute(c)
+		if !ok {
+			ch <- "none"
+			return
+		}
+		ch <- fmt.Sprint(ri.Methods, " ", ri.Pattern)
+	}
+	m.Get("/users/:name", handler)
+	m.Post(regexp.MustCompile(`^/posts/(?P<id>\d+)$`), handler)
+	m.Handle(idPattern{}, handler)
+	sub := New()
+	sub.Get("/:repo", handler)
+	m.Mount("/orgs/:org", sub)
+	m.NotFound(handler)
+
+	for _, test := range []struct {
+		method, path, expected string
+	}{
+		{"GET", "/users/carl", "[GET HEAD] /users/:name"},
+		{"POST", "/posts/42", `[POST] ^/posts/(?P<id>\d+)$`},
+		{"DELETE", "/id/7", "[] /id/{id}"},
+		{"GET", "/orgs/golang/go", "[GET HEAD] /orgs/:org/:repo"},
+		{"GET", "/nope", "none"},
+		// A 404 inside the mounted Mux
+		{"GET", "/orgs/golang/go/issues", "none"},
+	} {
+		r, _ := http.NewRequest(test.method, test.path, nil)
+		m.ServeHTTP(httptest.NewRecorder(), r)
+		if actual := <-ch; actual != test.expected {
+			t.Errorf("Expected %q for %s %s, got %q", test.expected,
+				test.method, test.path, actual)
+		}
+	}
+
+	r, _ := http.NewRequest("GET", "/orgs/golang/go/issues", nil)
+	c, matched := CaptureRoute(context.Background())
+	m.ServeHTTPC(c, httptest.NewRecorder(), r)
+	<-ch
+	if ri, ok := matched(); ok {
+		t.Errorf("Expected no captured route, got %q", ri.Pattern)
+	}
+}
+
+// wrappingPattern wraps the context it is given, as custom patterns may.
+type wrappingPattern struct {
+	Pattern
+}
+
+func (p wrappingPattern) Match(r *http.Request, c context.Context) (context.Context, bool) {
+	c, ok := p.Pattern.Match(r, c)
+	return context.WithValue(c, greetingKey, "hi"), ok
+}
+
+func TestHandlerContext(t *testing.T) {
+	t.Parallel()
+	m := New()
+	ch := make(chan map[string]string, 1)
+
+	handler := func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		// Handlers may use their context from other goroutines.
+		done := make(chan map[string]string)
+		go func() {
+			done <- URLParams(c)
+		}()
+		params := URLParams(c)
+		if p := <-done; p["name"] != params["name"] {
+			t.Errorf("Expected the same parameters, got %v and %v",
+				p, params)
+		}
+		if _, ok := MatchedRoute(c); !ok {
+			t.Error("Expected a matched route")
+		}
+		ch <- params
+	}
+	m.Get("/users/:name", handler)
+	m.Get(wrappingPattern{parsePattern("/wrapped/:name")}, handler)
+
+	for _, prefix := range []string{"/users/", "/wrapped/"} {
+		r, _ := http.NewRequest("GET", prefix+"alice", nil)
+		m.ServeHTTP(httptest.NewRecorder(), r)
+		params := <-ch
+
+		// Serve another request with the same (pooled) middleware
+		// stack: the map returned by URLParams must not change.
+		r, _ = http.NewRequest("GET", prefix+"bob", nil)
+		m.ServeHTTP(httptest.NewRecorder(), r)
+		<-ch
+
+		if params["name"] != "alice" {
+			t.Errorf("Expected URLParams for %s to bind name to "+
+				"alice, got %v", prefix, params)
+		}
+	}
+}
+
+func TestURLParamAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not counted reliably with -race")
+	}
+	m := New()
+	m.Get("/users/:user/comments/:comment", func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		URLParam(c, "user")
+		URLParam(c, "comment")
+	})
+	r, _ := http.NewRequest("GET", "/users/carl/comments/42", nil)
+	w := httptest.NewRecorder()
+
+	if n := testing.AllocsPerRun(100, func() {
+		m.ServeHTTPC(bgctx, w, r)
+	}); n != 0 {
+		t.Errorf("Expected routing and URLParam not to allocate, got %v "+
+			"allocations", n)
+	}
+}
@@ -0,0 +1,297 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"code.google.com/p/go.net/context"
+)
+
+// Route is a handle on a route that has been added to a Mux. It is returned by
+// each of the route-adding functions on Mux, and can be used to further
+// configure that route.
+//
+// Like the route-adding functions themselves, methods on a Route may be called
+// while requests are being served.
+type Route struct {
+	rt *router
+	r  *route
+}
+
+// Name registers the route under the given name, so that paths matching it can
+// later be built with Mux.URL. Each name may only be used once per Mux: Name
+// panics if another route was already registered under the same name. Name
+// returns the route to allow chaining.
+func (r *Route) Name(name string) *Route {
+	rt := r.rt
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	if other, ok := rt.names[name]; ok && other != r.r {
+		log.Panicf("web: a route is already named %q", name)
+	}
+	if r.r.name != "" {
+		delete(rt.names, r.r.name)
+	}
+	r.r.name = name
+	rt.names[name] = r.r
+	return r
+}
+
+// Set attaches a piece of metadata (for instance, the authorization scope the
+// route requires, or its rate-limiting class) to the route under the given key.
+// Middleware and handlers serving requests routed to it can retrieve the
+// metadata with RouteValue. As with context values, keys should be of a type of
+// their own to avoid collisions. Set returns the route to allow chaining.
+func (r *Route) Set(key, value interface{}) *Route {
+	r.update(func(nr *route) {
+		meta := make(map[interface{}]interface{}, len(nr.meta)+1)
+		for k, v := range nr.meta {
+			meta[k] = v
+		}
+		meta[key] = value
+		nr.meta = meta
+	})
+	return r
+}
+
+// Use appends the given middleware to the route's own middleware stack, which
+// only wraps the route's handler. Route middleware runs once the route has been
+// selected, after the Mux's middleware and the middleware of the route's group,
+// if any. See the documentation for type Mux for a list of valid middleware
+// types. Use returns the route to allow chaining.
+func (r *Route) Use(middleware interface{}) *Route {
+	checkLayer(middleware)
+	r.rt.lock.Lock()
+	ms := r.r.ms
+	r.rt.lock.Unlock()
+
+	if ms == nil {
+		r.update(func(nr *route) {
+			if nr.ms == nil {
+				nr.ms = newRouteStack(make([]interface{}, 0),
+					nr.handler)
+				nr.handler = nr.ms
+			}
+			ms = nr.ms
+		})
+	}
+	ms.Use(middleware)
+	return r
+}
+
+// update replaces the route with a modified copy of itself. Like the list of
+// routes, routes are copied on write, so that requests being routed are not
+// affected.
+func (r *Route) update(fn func(nr *route)) {
+	rt := r.rt
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	nr := *r.r
+	fn(&nr)
+
+	routes := make([]*route, len(rt.routes))
+	for i, route := range rt.routes {
+		if route == r.r {
+			route = &nr
+		}
+		routes[i] = route
+	}
+	if nr.name != "" {
+		rt.names[nr.name] = &nr
+	}
+	rt.setMachine(nil)
+	rt.routes = routes
+	r.r = &nr
+}
+
+// matchedRoute records which route of which router matched a request.
+type matchedRoute struct {
+	rt *router
+	r  *route
+}
+
+// withRoute returns the context in which the handler of the route which matched
+// the request is called, given the context the request was routed in and the
+// one returned by the route's pattern. Within a Mux, c is a routeParams.
+func (rt *router) withRoute(c, rc context.Context, r *route) context.Context {
+	m := matchedRoute{rt, r}
+	if capture, ok := c.Value(captureKey).(*routeCapture); ok {
+		capture.m = m
+	}
+	rp, ok := c.(*routeParams)
+	if !ok {
+		return context.WithValue(rc, routeKey, &matchedRoute{rt, r})
+	}
+	if rc == rp {
+		return rp.route(m)
+	}
+	// A custom pattern returned a context of its own, derived from the
+	// routeParams, which can therefore no longer be reused.
+	rp.escape(m)
+	return rc
+}
+
+// RouteValue returns the metadata stored under the given key (see Route.Set) on
+// the route which matched the request, or nil if there is none. It can be used
+// by route and group middleware, as well as by handlers.
+func RouteValue(ctx context.Context, key interface{}) interface{} {
+	if m, _ := ctx.Value(routeKey).(*matchedRoute); m != nil {
+		return m.r.meta[key]
+	}
+	return nil
+}
+
+/*
+MatchedRoute describes the route which matched the request, if any. Its Pattern
+is the template of the route's pattern (see Pattern), rather than the path of
+the request, which makes it suitable for labelling logs and metrics without
+creating a label per URL. The patterns of routes of a Mux mounted on another
+(see Mux.Mount) are prefixed with the prefix the Mux was mounted under, so that
+a route for "/:name" of a Mux mounted under "/users" is described as
+"/users/:name".
+
+MatchedRoute can be used by route and group middleware, as well as by handlers.
+It returns false if the request has not been routed yet, or if it matched no
+route. In particular, the route under which a Mux is mounted is not reported to
+that Mux's NotFound handler.
+*/
+func MatchedRoute(ctx context.Context) (RouteInfo, bool) {
+	m, _ := ctx.Value(routeKey).(*matchedRoute)
+	if m == nil {
+		return RouteInfo{}, false
+	}
+	return m.info(), true
+}
+
+func (m *matchedRoute) info() RouteInfo {
+	info := m.r.info()
+	for mp := m.rt.getMount(); mp != nil; mp = mp.parent.getMount() {
+		info.Pattern = strings.TrimSuffix(mp.pattern.raw, "/*") +
+			info.Pattern
+	}
+	return info
+}
+
+type routeCapture struct {
+	m matchedRoute
+}
+
+/*
+CaptureRoute returns a copy of ctx in which Muxes record the route the request
+is routed to, along with a function which describes that route like MatchedRoute
+does, once the request has been served with the returned context. It allows
+middleware which runs before the request is routed, such as request loggers, to
+learn which route served it:
+
+	func logger(c context.Context, w http.ResponseWriter, r *http.Request, next web.Handler) {
+		c, matched := web.CaptureRoute(c)
+		next.ServeHTTPC(c, w, r)
+		if route, ok := matched(); ok {
+			log.Printf("%s %s", r.Method, route.Pattern)
+		}
+	}
+
+If the request is routed by several Muxes (see Mux.Mount), the route of the
+innermost Mux is described.
+*/
+func CaptureRoute(ctx context.Context) (context.Context, func() (RouteInfo, bool)) {
+	capture := &routeCapture{}
+	return context.WithValue(ctx, captureKey, capture), func() (RouteInfo, bool) {
+		if capture.m.r == nil {
+			return RouteInfo{}, false
+		}
+		return capture.m.info(), true
+	}
+}
+
+// RouteInfo describes a single route registered on a Mux. It is a snapshot:
+// modifying it has no effect on the Mux it was obtained from.
+type RouteInfo struct {
+	// Methods is the sorted list of HTTP methods the route responds to. It
+	// is nil for routes added with Handle, which match every method.
+	Methods []string
+	// Pattern is a human-readable representation of the route's pattern.
+	// For Sinatra-like patterns this is the string the route was added
+	// with, and for regular expressions it is the (left-anchored) source
+	// of the regexp. Custom patterns are described by their String method
+	// if they have one.
+	Pattern string
+	// Handler is the handler exactly as it was passed when the route was
+	// added, which allows callers to determine its identity.
+	Handler interface{}
+	// Name is the name the route was registered under with Route.Name, or
+	// the empty string.
+	Name string
+}
+
+// urlBuilder is implemented by the patterns which can be reversed, i.e., which
+// are able to produce a path they would match given the values of their
+// parameters.
+type urlBuilder interface {
+	buildPath(params map[string]string) (string, error)
+}
+
+// patternString returns a human-readable representation of the given pattern.
+func patternString(p Pattern) string {
+	switch v := p.(type) {
+	case stringPattern:
+		return v.raw
+	case regexpPattern:
+		return v.re.String()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprintf("%T", p)
+	}
+}
+
+func (r route) info() RouteInfo {
+	ri := RouteInfo{
+		Pattern: patternString(r.pattern),
+		Handler: r.raw,
+		Name:    r.name,
+	}
+	if r.method != mALL {
+		ri.Methods = methodSet(r.method).names()
+	}
+	return ri
+}
+
+func (rt *router) routeInfos() []RouteInfo {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	infos := make([]RouteInfo, len(rt.routes))
+	for i, r := range rt.routes {
+		infos[i] = r.info()
+	}
+	return infos
+}
+
+func (rt *router) url(name string, params map[string]string) (string, error) {
+	rt.lock.Lock()
+	r, ok := rt.names[name]
+	rt.lock.Unlock()
+	if !ok {
+		return "", fmt.Errorf("web: no route named %q", name)
+	}
+
+	b, ok := r.pattern.(urlBuilder)
+	if !ok {
+		return "", fmt.Errorf("web: route %q has a pattern of type %T, "+
+			"which cannot be reversed", name, r.pattern)
+	}
+	path, err := b.buildPath(params)
+	if err != nil {
+		return "", fmt.Errorf("web: route %q: %v", name, err)
+	}
+	if path, err = rt.mountedPath(path, params); err != nil {
+		return "", fmt.Errorf("web: route %q: %v", name, err)
+	}
+	u := url.URL{Path: path}
+	return u.String(), nil
+}
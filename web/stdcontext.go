@@ -0,0 +1,45 @@
+// +build go1.7
+
+package web
+
+import (
+	"net/http"
+
+	"code.google.com/p/go.net/context"
+)
+
+/*
+Since Go 1.7, requests carry a context of their own, which has the same methods
+as the contexts used by this package. Requests are served in the context of the
+request, and if Mux.RequestContext is enabled, the context is stored on the
+request (with http.Request's WithContext) each time it changed before the
+request is handed to a net/http handler or middleware, so that they have access
+to the URL parameters and to the values set by middleware. The context is read
+back from the request when it comes back from an http.Handler middleware.
+*/
+
+// baseContext returns the context requests served by Mux.ServeHTTP start with.
+func baseContext(r *http.Request) context.Context {
+	return r.Context()
+}
+
+// withContext returns a request carrying the given context if the request
+// already does, or if storing contexts on requests is enabled, either by the
+// caller or by the Mux which routed the request. It also returns whether the
+// returned request carries the context.
+func withContext(c context.Context, r *http.Request, enabled bool) (*http.Request, bool) {
+	if r.Context() == c {
+		return r, true
+	}
+	if !enabled && c.Value(requestContextKey) == nil {
+		return r, false
+	}
+	return r.WithContext(c), true
+}
+
+// contextOf returns the context carried by a request coming back from an
+// http.Handler middleware, which may have derived a new context from the one
+// it was given.
+func contextOf(r *http.Request, c context.Context) context.Context {
+	return r.Context()
+}
@@ -0,0 +1,23 @@
+// +build !go1.7
+
+package web
+
+import (
+	"net/http"
+
+	"code.google.com/p/go.net/context"
+)
+
+// Before Go 1.7, requests do not carry a context: see stdcontext.go.
+
+func baseContext(r *http.Request) context.Context {
+	return bgctx
+}
+
+func withContext(c context.Context, r *http.Request, enabled bool) (*http.Request, bool) {
+	return r, false
+}
+
+func contextOf(r *http.Request, c context.Context) context.Context {
+	return c
+}
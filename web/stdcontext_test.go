@@ -0,0 +1,83 @@
+// +build go1.7
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code.google.com/p/go.net/context"
+)
+
+type stdContextKey string
+
+func TestStdContext(t *testing.T) {
+	t.Parallel()
+	m := New()
+	m.RequestContext(true)
+	ch := make(chan string, 1)
+
+	// An http.Handler middleware deriving a new context from the request's.
+	m.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c := context.WithValue(r.Context(), stdContextKey("http"), "a")
+			h.ServeHTTP(w, r.WithContext(c))
+		})
+	})
+	m.Use(func(h Handler) Handler {
+		return HandlerFunc(func(c context.Context, w http.ResponseWriter, r *http.Request) {
+			c = context.WithValue(c, stdContextKey("web"), "b")
+			h.ServeHTTPC(c, w, r)
+		})
+	})
+
+	m.Get("/plain/:name", func(w http.ResponseWriter, r *http.Request) {
+		c := r.Context()
+		name, _ := URLParam(c, "name")
+		ch <- name + c.Value(stdContextKey("base")).(string) +
+			c.Value(stdContextKey("http")).(string) +
+			c.Value(stdContextKey("web")).(string)
+	})
+	m.Get("/ctx/:name", func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		name, _ := URLParam(c, "name")
+		ch <- name + c.Value(stdContextKey("base")).(string) +
+			c.Value(stdContextKey("http")).(string) +
+			c.Value(stdContextKey("web")).(string)
+	})
+
+	for path, expected := range map[string]string{
+		"/plain/carl": "carl0ab",
+		"/ctx/carl":   "carl0ab",
+	} {
+		r, _ := http.NewRequest("GET", path, nil)
+		r = r.WithContext(context.WithValue(r.Context(), stdContextKey("base"), "0"))
+		m.ServeHTTP(httptest.NewRecorder(), r)
+		if actual := <-ch; actual != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, path, actual)
+		}
+	}
+}
+
+func TestStdContextDisabled(t *testing.T) {
+	t.Parallel()
+	m := New()
+	var given *http.Request
+	var name string
+	m.Get("/plain/:name", func(w http.ResponseWriter, r *http.Request) {
+		given = r
+		name, _ = URLParam(r.Context(), "name")
+	})
+
+	r, _ := http.NewRequest("GET", "/plain/carl", nil)
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	if given != r || name != "" {
+		t.Error("Expected the handler to get the request given to the Mux")
+	}
+
+	m.RequestContext(true)
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	if name != "carl" {
+		t.Errorf(`Expected "carl" once enabled, got %q`, name)
+	}
+}
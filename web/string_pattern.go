@@ -1,7 +1,9 @@
 package web
 
 import (
+	"bytes"
 	"fmt"
+	"log"
 	"net/http"
 	"regexp"
 	"strings"
@@ -15,7 +17,26 @@ type stringPattern struct {
 	pats     []string
 	breaks   []byte
 	literals []string
-	wildcard bool
+	// The constraint each parameter's value must satisfy, or nil.
+	constraints []*regexp.Regexp
+	wildcard    bool
+	// The name the tail of the path is bound to if wildcard is set: "*",
+	// unless the pattern ends with a named catch-all like "/*filepath".
+	wildcardName string
+	// Patterns with optional segments or with a catch-all which is not at
+	// their end are matched by trying each of their variants in turn. The
+	// fields above only describe the literal prefix of such patterns.
+	variants [][]patternToken
+}
+
+// patternToken is a single element of a variant of a string pattern: either a
+// literal, a parameter, or a catch-all.
+type patternToken struct {
+	literal    string
+	name       string
+	brk        byte
+	constraint *regexp.Regexp
+	catchall   bool
 }
 
 func (s stringPattern) Prefix() string {
@@ -28,9 +49,17 @@ func (s stringPattern) Match(r *http.Request, c context.Context) (context.Contex
 	return s.match(r, c, false)
 }
 func (s stringPattern) match(r *http.Request, c context.Context, dryrun bool) (context.Context, bool) {
+	if s.variants != nil {
+		return s.matchVariants(r, c, dryrun)
+	}
+
 	path := r.URL.Path
+	// When routing within a Mux, parameters are added directly to the
+	// routeParams. This is safe since we only get here once the dry run
+	// has succeeded.
+	rp, direct := c.(*routeParams)
 	var matches map[string]string
-	if !dryrun {
+	if !dryrun && !direct {
 		if s.wildcard {
 			matches = make(map[string]string, len(s.pats)+1)
 		} else if len(s.pats) != 0 {
@@ -56,7 +85,14 @@ func (s stringPattern) match(r *http.Request, c context.Context, dryrun bool) (c
 			// "/:foo" would match the path "/"
 			return c, false
 		}
-		if !dryrun {
+		if re := s.constraints[i]; re != nil && !re.MatchString(path[:m]) {
+			return c, false
+		}
+		switch {
+		case dryrun:
+		case direct:
+			rp.add(pat, path[:m])
+		default:
 			matches[pat] = path[:m]
 		}
 		path = path[m:]
@@ -67,20 +103,65 @@ func (s stringPattern) match(r *http.Request, c context.Context, dryrun bool) (c
 		if !strings.HasPrefix(path, tail) {
 			return c, false
 		}
-		if !dryrun {
-			matches["*"] = path[len(tail)-1:]
+		switch {
+		case dryrun:
+		case direct:
+			rp.add(s.wildcardName, path[len(tail)-1:])
+		default:
+			matches[s.wildcardName] = path[len(tail)-1:]
 		}
 	} else if path != tail {
 		return c, false
 	}
 
-	if c == nil || dryrun || len(s.pats) == 0 {
+	if c == nil || dryrun || matches == nil {
 		return c, true
 	}
 
 	return withURLParams(c, matches), true
 }
 
+func (s stringPattern) buildPath(params map[string]string) (string, error) {
+	if s.variants != nil {
+		return s.buildVariants(params)
+	}
+
+	var buf bytes.Buffer
+	for i, pat := range s.pats {
+		buf.WriteString(s.literals[i])
+		v, ok := params[pat]
+		if !ok {
+			return "", fmt.Errorf("missing parameter %q", pat)
+		}
+		// Mirror match: values are non-empty, and may contain neither a
+		// slash nor the character that ends them.
+		if v == "" || strings.IndexByte(v, '/') != -1 ||
+			strings.IndexByte(v, s.breaks[i]) != -1 ||
+			(s.constraints[i] != nil && !s.constraints[i].MatchString(v)) {
+			return "", fmt.Errorf("invalid value %q for parameter %q",
+				v, pat)
+		}
+		buf.WriteString(v)
+	}
+	tail := s.literals[len(s.pats)]
+	if s.wildcard {
+		v, ok := params[s.wildcardName]
+		if !ok {
+			return "", fmt.Errorf("missing parameter %q", s.wildcardName)
+		}
+		if !strings.HasPrefix(v, "/") {
+			return "", fmt.Errorf("invalid value %q for parameter %q",
+				v, s.wildcardName)
+		}
+		// The tail includes the slash which precedes the wildcard.
+		buf.WriteString(tail[:len(tail)-1])
+		buf.WriteString(v)
+	} else {
+		buf.WriteString(tail)
+	}
+	return buf.String(), nil
+}
+
 func (s stringPattern) String() string {
 	return fmt.Sprintf("stringPattern(%q)", s.raw)
 }
@@ -91,38 +172,378 @@ func (s stringPattern) String() string {
 // and "," were chosen because Section 3.3 of RFC 3986 suggests their use.
 const bc = "/.;,"
 
-var patternRe = regexp.MustCompile(`[` + bc + `]:([^` + bc + `]+)`)
+// Named constraints that can be used in place of a regular expression, as in
+// "/users/:id{int}".
+var namedConstraints = map[string]string{
+	"int":   `-?[0-9]+`,
+	"uint":  `[0-9]+`,
+	"hex":   `[0-9a-fA-F]+`,
+	"alpha": `[a-zA-Z]+`,
+	"alnum": `[a-zA-Z0-9]+`,
+	"uuid":  `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+}
 
 func parseStringPattern(s string) stringPattern {
 	raw := s
+	if strings.Contains(s, "(/") {
+		return parseVariantPattern(s)
+	}
+	wildcardName := "*"
+	if i := strings.Index(s, "/*"); i != -1 {
+		name := s[i+2:]
+		if strings.Contains(name, "/") {
+			// The catch-all is not at the end of the pattern.
+			return parseVariantPattern(s)
+		}
+		if name != "" {
+			wildcardName = name
+			s = s[:i+2]
+		}
+	}
+
 	var wildcard bool
 	if strings.HasSuffix(s, "/*") {
 		s = s[:len(s)-1]
 		wildcard = true
 	}
 
-	matches := patternRe.FindAllStringSubmatchIndex(s, -1)
-	pats := make([]string, len(matches))
-	breaks := make([]byte, len(matches))
-	literals := make([]string, len(matches)+1)
+	var pats, literals []string
+	var breaks []byte
+	var constraints []*regexp.Regexp
 	n := 0
-	for i, match := range matches {
-		a, b := match[2], match[3]
-		literals[i] = s[n : a-1] // Need to leave off the colon
-		pats[i] = s[a:b]
+	for i := 1; i < len(s); i++ {
+		// A pattern is a colon following a break character, followed by
+		// a non-empty name and an optional constraint in braces.
+		if s[i] != ':' || strings.IndexByte(bc, s[i-1]) == -1 {
+			continue
+		}
+		a, b := i+1, i+1
+		for b < len(s) && s[b] != '{' && strings.IndexByte(bc, s[b]) == -1 {
+			b++
+		}
+		if a == b {
+			continue
+		}
+		pat := s[a:b]
+		var constraint *regexp.Regexp
+		if b < len(s) && s[b] == '{' {
+			end := closingBrace(s, b)
+			if end == -1 {
+				log.Panicf("web: unterminated constraint for "+
+					"parameter %q in pattern %q", pat, raw)
+			}
+			constraint = parseConstraint(raw, pat, s[b+1:end])
+			b = end + 1
+		}
+
+		literals = append(literals, s[n:i]) // Need to leave off the colon
+		pats = append(pats, pat)
+		constraints = append(constraints, constraint)
 		if b == len(s) {
-			breaks[i] = '/'
+			breaks = append(breaks, '/')
 		} else {
-			breaks[i] = s[b]
+			breaks = append(breaks, s[b])
 		}
 		n = b
+		i = b
+	}
+	literals = append(literals, s[n:])
+	return stringPattern{
+		raw:          raw,
+		pats:         pats,
+		breaks:       breaks,
+		literals:     literals,
+		constraints:  constraints,
+		wildcard:     wildcard,
+		wildcardName: wildcardName,
+	}
+}
+
+// closingBrace returns the index of the brace closing the one at s[i], or -1 if
+// there is none. Braces may be nested (as in "{[0-9]{4}}") or escaped with a
+// backslash.
+func closingBrace(s string, i int) int {
+	depth := 0
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func parseConstraint(raw, pat, c string) *regexp.Regexp {
+	if named, ok := namedConstraints[c]; ok {
+		c = named
+	}
+	re, err := regexp.Compile(`\A(?:` + c + `)\z`)
+	if err != nil {
+		log.Panicf("web: invalid constraint for parameter %q in "+
+			"pattern %q: %v", pat, raw, err)
+	}
+	return re
+}
+
+// parseVariantPattern parses patterns with optional segments or with catch-alls
+// which are not at their end. Such patterns are expanded to the list of their
+// variants: "/posts(/:page)", for instance, has two variants, "/posts/:page" and
+// "/posts", which are tried in that order.
+func parseVariantPattern(s string) stringPattern {
+	variants, i := parseVariants(s, 0, false)
+	if i != len(s) {
+		log.Panicf("web: unbalanced parenthesis at offset %d in pattern "+
+			"%q", i, s)
+	}
+	// Each catch-all in the middle of a pattern multiplies the number of
+	// ways to match a path by its number of segments.
+	for _, v := range variants {
+		n := 0
+		for j, t := range v {
+			if t.catchall && j < len(v)-1 {
+				n++
+			}
+		}
+		if n > 1 {
+			log.Panicf("web: pattern %q has more than one catch-all "+
+				"before its end", s)
+		}
+	}
+
+	// Only the literal shared by every variant can be used as a prefix.
+	var prefix string
+	for i, v := range variants {
+		lead := ""
+		if len(v) != 0 && v[0].name == "" {
+			lead = v[0].literal
+		}
+		if i == 0 {
+			prefix = lead
+			continue
+		}
+		n := 0
+		for n < len(prefix) && n < len(lead) && prefix[n] == lead[n] {
+			n++
+		}
+		prefix = prefix[:n]
 	}
-	literals[len(matches)] = s[n:]
+
 	return stringPattern{
-		raw:      raw,
-		pats:     pats,
-		breaks:   breaks,
-		literals: literals,
-		wildcard: wildcard,
+		raw:      s,
+		literals: []string{prefix},
+		variants: variants,
+	}
+}
+
+// parseVariants parses s, starting at offset i, until its end or, if nested is
+// set, until the parenthesis closing the current optional segment. It returns
+// the variants of what it parsed, and the offset at which it stopped.
+func parseVariants(s string, i int, nested bool) ([][]patternToken, int) {
+	variants := [][]patternToken{nil}
+	var lit bytes.Buffer
+	add := func(tokens ...patternToken) {
+		if lit.Len() != 0 {
+			tokens = append([]patternToken{{literal: lit.String()}},
+				tokens...)
+			lit.Reset()
+		}
+		for j, v := range variants {
+			variants[j] = append(v[:len(v):len(v)], tokens...)
+		}
+	}
+
+	for i < len(s) {
+		switch {
+		case strings.HasPrefix(s[i:], "(/"):
+			sub, end := parseVariants(s, i+1, true)
+			if end == len(s) {
+				log.Panicf("web: unterminated optional segment at "+
+					"offset %d in pattern %q", i, s)
+			}
+			add()
+			// The variants which include the optional segment are
+			// tried first.
+			var out [][]patternToken
+			for _, v := range variants {
+				for _, o := range sub {
+					out = append(out, append(v[:len(v):len(v)], o...))
+				}
+				out = append(out, v)
+			}
+			variants = out
+			i = end + 1
+		case s[i] == ')' && nested:
+			add()
+			return variants, i
+		case s[i] == '*' && i > 0 && s[i-1] == '/':
+			j := i + 1
+			for j < len(s) && strings.IndexByte("/()", s[j]) == -1 {
+				j++
+			}
+			name := s[i+1 : j]
+			if name == "" {
+				name = "*"
+			}
+			// Like "*", catch-alls include the slash which precedes
+			// them.
+			lit.Truncate(lit.Len() - 1)
+			add(patternToken{name: name, catchall: true})
+			i = j
+		case s[i] == ':' && i > 0 && strings.IndexByte(bc, s[i-1]) != -1:
+			j := i + 1
+			for j < len(s) && strings.IndexByte(bc+"{()", s[j]) == -1 {
+				j++
+			}
+			if j == i+1 {
+				lit.WriteByte(s[i])
+				i++
+				continue
+			}
+			t := patternToken{name: s[i+1 : j], brk: '/'}
+			if j < len(s) && s[j] == '{' {
+				end := closingBrace(s, j)
+				if end == -1 {
+					log.Panicf("web: unterminated constraint for "+
+						"parameter %q in pattern %q", t.name, s)
+				}
+				t.constraint = parseConstraint(s, t.name, s[j+1:end])
+				j = end + 1
+			}
+			if j < len(s) && strings.IndexByte(bc, s[j]) != -1 {
+				t.brk = s[j]
+			}
+			add(t)
+			i = j
+		default:
+			lit.WriteByte(s[i])
+			i++
+		}
+	}
+	add()
+	return variants, i
+}
+
+func (s stringPattern) matchVariants(r *http.Request, c context.Context, dryrun bool) (context.Context, bool) {
+	for _, v := range s.variants {
+		var matches map[string]string
+		if !dryrun {
+			matches = make(map[string]string)
+		}
+		if !matchTokens(v, r.URL.Path, matches) {
+			continue
+		}
+		if c == nil || dryrun || len(matches) == 0 {
+			return c, true
+		}
+		return withURLParams(c, matches), true
+	}
+	return c, false
+}
+
+// matchTokens reports whether path matches the given tokens, binding their
+// values in matches unless it is nil.
+func matchTokens(tokens []patternToken, path string, matches map[string]string) bool {
+	if len(tokens) == 0 {
+		return path == ""
+	}
+	t := tokens[0]
+	switch {
+	case t.name == "":
+		return strings.HasPrefix(path, t.literal) &&
+			matchTokens(tokens[1:], path[len(t.literal):], matches)
+	case t.catchall:
+		if !strings.HasPrefix(path, "/") {
+			return false
+		}
+		if len(tokens) == 1 {
+			if matches != nil {
+				matches[t.name] = path
+			}
+			return true
+		}
+		// Catch-alls in the middle of a pattern match as many whole
+		// segments as they can, but at least one character after the
+		// slash.
+		for m := len(path); m > 1; m-- {
+			if m < len(path) && path[m] != '/' {
+				continue
+			}
+			if matchTokens(tokens[1:], path[m:], matches) {
+				if matches != nil {
+					matches[t.name] = path[:m]
+				}
+				return true
+			}
+		}
+		return false
+	default:
+		m := 0
+		for ; m < len(path); m++ {
+			if path[m] == t.brk || path[m] == '/' {
+				break
+			}
+		}
+		if m == 0 {
+			return false
+		}
+		if t.constraint != nil && !t.constraint.MatchString(path[:m]) {
+			return false
+		}
+		if !matchTokens(tokens[1:], path[m:], matches) {
+			return false
+		}
+		if matches != nil {
+			matches[t.name] = path[:m]
+		}
+		return true
+	}
+}
+
+// buildVariants builds the path of the first variant for which every parameter
+// was given, so that optional segments are included whenever possible.
+func (s stringPattern) buildVariants(params map[string]string) (string, error) {
+	var err error
+	for _, v := range s.variants {
+		var path string
+		if path, err = buildTokens(v, params); err == nil {
+			return path, nil
+		}
+	}
+	return "", err
+}
+
+func buildTokens(tokens []patternToken, params map[string]string) (string, error) {
+	var buf bytes.Buffer
+	for i, t := range tokens {
+		if t.name == "" {
+			buf.WriteString(t.literal)
+			continue
+		}
+		v, ok := params[t.name]
+		if !ok {
+			return "", fmt.Errorf("missing parameter %q", t.name)
+		}
+		var valid bool
+		if t.catchall {
+			valid = strings.HasPrefix(v, "/") &&
+				(i == len(tokens)-1 || len(v) > 1)
+		} else {
+			valid = v != "" && strings.IndexByte(v, '/') == -1 &&
+				strings.IndexByte(v, t.brk) == -1 &&
+				(t.constraint == nil || t.constraint.MatchString(v))
+		}
+		if !valid {
+			return "", fmt.Errorf("invalid value %q for parameter %q",
+				v, t.name)
+		}
+		buf.WriteString(v)
 	}
+	return buf.String(), nil
 }
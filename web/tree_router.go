@@ -0,0 +1,265 @@
+package web
+
+/*
+This file implements an alternative to the bytecode router (see
+bytecode_compiler.go), enabled with Mux.TreeRouting, which is better suited to
+applications with a large number of routes.
+
+Most routes in such applications use Sinatra-like patterns made of whole path
+segments, each of which is either static ("/users") or a named parameter
+("/:name"). We call these patterns "segment patterns", and index them in a tree
+with one level per path segment. Routing a request to the segment patterns is
+then a single walk down the tree, during which we collect every segment pattern
+which matches the path, regardless of how many routes there are. Since each
+parameter occupies a whole segment, the value of each parameter can be read
+directly off the split path, without calling the pattern's Match function.
+
+Every other route (regular expressions, custom patterns, or string patterns
+with parameters which do not span a whole segment, like "/:name.:ext") is kept
+in a plain list. To preserve the guarantee that the first matching route wins,
+the candidates found in the tree and the other routes are merged by their
+position in the router's list of routes, and examined in that order.
+*/
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strings"
+
+	"code.google.com/p/go.net/context"
+)
+
+type treeNode struct {
+	static map[string]*treeNode
+	param  *treeNode
+	// Indexes of the routes whose pattern ends at this node.
+	routes []int
+	// Indexes of the routes whose pattern ends with "/*" at this node.
+	wildcards []int
+}
+
+// segmentRoute describes how a route's segment pattern binds its parameters.
+type segmentRoute struct {
+	// For each parameter, the index of the segment holding its value.
+	segs     []int
+	names    []string
+	wildcard bool
+	depth    int
+}
+
+type routeTree struct {
+	root     treeNode
+	segments map[int]segmentRoute
+	// Indexes of the routes which are not in the tree.
+	others []int
+}
+
+// paramSegment stands for a parameter in the segments of a segment pattern.
+// Static segments are never looked up in a way that could confuse the two.
+const paramSegment = "\x00"
+
+// segmentPattern returns the segments of the given string pattern, or false if
+// it is not a segment pattern.
+func segmentPattern(s stringPattern) ([]string, bool) {
+	if s.variants != nil || !strings.HasPrefix(s.raw, "/") {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	for i := range s.pats {
+		if s.breaks[i] != '/' || !strings.HasSuffix(s.literals[i], "/") {
+			return nil, false
+		}
+		buf.WriteString(s.literals[i])
+		buf.WriteString(paramSegment)
+	}
+	buf.WriteString(s.literals[len(s.pats)])
+
+	segs := strings.Split(buf.String()[1:], "/")
+	if s.wildcard {
+		// The pattern ends in the slash preceding the "*", which gave
+		// us an extra, empty, segment.
+		segs = segs[:len(segs)-1]
+	}
+	return segs, true
+}
+
+func buildTree(routes []*route) *routeTree {
+	t := &routeTree{segments: make(map[int]segmentRoute)}
+	for i, r := range routes {
+		sp, ok := r.pattern.(stringPattern)
+		if !ok {
+			t.others = append(t.others, i)
+			continue
+		}
+		segs, ok := segmentPattern(sp)
+		if !ok {
+			t.others = append(t.others, i)
+			continue
+		}
+
+		sr := segmentRoute{
+			names:    sp.pats,
+			wildcard: sp.wildcard,
+			depth:    len(segs),
+		}
+		n := &t.root
+		for j, seg := range segs {
+			if seg == paramSegment {
+				sr.segs = append(sr.segs, j)
+				if n.param == nil {
+					n.param = &treeNode{}
+				}
+				n = n.param
+				continue
+			}
+			if n.static == nil {
+				n.static = make(map[string]*treeNode)
+			}
+			child, ok := n.static[seg]
+			if !ok {
+				child = &treeNode{}
+				n.static[seg] = child
+			}
+			n = child
+		}
+		if sp.wildcard {
+			n.wildcards = append(n.wildcards, i)
+		} else {
+			n.routes = append(n.routes, i)
+		}
+		t.segments[i] = sr
+	}
+	return t
+}
+
+// splitPath appends the segments of the given path, which must start with a
+// slash, to segs.
+func splitPath(segs []string, path string) []string {
+	path = path[1:]
+	for {
+		i := strings.IndexByte(path, '/')
+		if i == -1 {
+			return append(segs, path)
+		}
+		segs = append(segs, path[:i])
+		path = path[i+1:]
+	}
+}
+
+// collect appends the indexes of every route in the tree whose pattern matches
+// the given path segments.
+func (n *treeNode) collect(segs []string, depth int, out []int) []int {
+	if depth == len(segs) {
+		return append(out, n.routes...)
+	}
+	out = append(out, n.wildcards...)
+	if child, ok := n.static[segs[depth]]; ok {
+		out = child.collect(segs, depth+1, out)
+	}
+	if n.param != nil && segs[depth] != "" {
+		out = n.param.collect(segs, depth+1, out)
+	}
+	return out
+}
+
+func (t *routeTree) route(routes []*route, c context.Context, r *http.Request) (methodSet, context.Context, *route) {
+	m := httpMethod(r.Method)
+	var methods methodSet
+	path := r.URL.Path
+	rp, _ := c.(*routeParams)
+
+	var segs []string
+	var cands []int
+	if strings.HasPrefix(path, "/") {
+		if rp != nil {
+			segs = splitPath(rp.segs[:0], path)
+			rp.segs = segs
+		} else {
+			segs = splitPath(nil, path)
+		}
+		var buf [8]int
+		cands = t.root.collect(segs, 0, buf[:0])
+		sort.Ints(cands)
+	}
+
+	i, j := 0, 0
+	for i < len(cands) || j < len(t.others) {
+		var idx int
+		inTree := j == len(t.others) ||
+			(i < len(cands) && cands[i] < t.others[j])
+		if inTree {
+			idx = cands[i]
+			i++
+		} else {
+			idx = t.others[j]
+			j++
+		}
+		route := routes[idx]
+
+		if !inTree {
+			if !strings.HasPrefix(path, route.prefix) {
+				continue
+			}
+			mark := rp.mark()
+			mc, ok := route.pattern.Match(r, c)
+			if ok && route.method&m != 0 {
+				return 0, mc, route
+			}
+			rp.truncate(mark)
+			if ok {
+				methods |= methodSet(route.method)
+			}
+			continue
+		}
+
+		sp := route.pattern.(stringPattern)
+		sr := t.segments[idx]
+		ok := true
+		for k, seg := range sr.segs {
+			if re := sp.constraints[k]; re != nil && !re.MatchString(segs[seg]) {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		if route.method&m == 0 {
+			methods |= methodSet(route.method)
+			continue
+		}
+		if len(sr.segs) == 0 && !sr.wildcard {
+			return 0, c, route
+		}
+		var matches map[string]string
+		add := func(k, v string) {
+			if rp != nil {
+				rp.add(k, v)
+				return
+			}
+			if matches == nil {
+				matches = make(map[string]string, len(sr.segs)+1)
+			}
+			matches[k] = v
+		}
+		for k, seg := range sr.segs {
+			add(sr.names[k], segs[seg])
+		}
+		if sr.wildcard {
+			// Skip the leading slash and the first depth segments
+			// (and their trailing slashes), but keep the slash
+			// which precedes the tail.
+			off := 0
+			for _, seg := range segs[:sr.depth] {
+				off += len(seg) + 1
+			}
+			add(sp.wildcardName, path[off:])
+		}
+		if rp != nil {
+			return 0, rp, route
+		}
+		return 0, withURLParams(c, matches), route
+	}
+	return methods, c, nil
+}
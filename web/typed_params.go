@@ -0,0 +1,292 @@
+package web
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.net/context"
+)
+
+// ErrMissingParam is the error wrapped by a ParamError when the request has no
+// value for the parameter, which can happen for parameters in optional segments
+// of a pattern (or if the parameter's name is misspelled).
+var ErrMissingParam = errors.New("missing parameter")
+
+// ParamError is the error returned by the typed parameter accessors (ParamInt,
+// ParamUUID, ParamTime and BindParams) when a URL parameter is missing or
+// cannot be converted to the requested type.
+//
+// Since URL parameters come from the client, a ParamError usually means that
+// the request was malformed: its StatusCode method returns 400 (Bad Request),
+// and handlers which return it to the router are answered with that status
+// (see ErrorHandlerFunc):
+//
+//	m.Get("/users/:id", func(c context.Context, w http.ResponseWriter, r *http.Request) error {
+//		id, err := web.ParamInt(c, "id")
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	})
+type ParamError struct {
+	// Name is the name of the parameter.
+	Name string
+	// Value is the value of the parameter, if any.
+	Value string
+	// Type describes the type the value could not be converted to.
+	Type string
+	// Err is the underlying error, or ErrMissingParam.
+	Err error
+}
+
+func (e *ParamError) Error() string {
+	if e.Err == ErrMissingParam {
+		return fmt.Sprintf("web: missing URL parameter %q", e.Name)
+	}
+	return fmt.Sprintf("web: invalid %s %q for URL parameter %q: %v",
+		e.Type, e.Value, e.Name, e.Err)
+}
+
+// StatusCode returns the HTTP status code appropriate for responding to a
+// request with an invalid parameter, http.StatusBadRequest.
+func (e *ParamError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+func param(ctx context.Context, name, typ string) (string, error) {
+	v, ok := URLParam(ctx, name)
+	if !ok {
+		return "", &ParamError{Name: name, Type: typ, Err: ErrMissingParam}
+	}
+	return v, nil
+}
+
+// ParamInt returns the value of the named URL parameter as a (decimal) int.
+func ParamInt(ctx context.Context, name string) (int, error) {
+	v, err := param(ctx, name, "int")
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, &ParamError{Name: name, Value: v, Type: "int",
+			Err: err.(*strconv.NumError).Err}
+	}
+	return i, nil
+}
+
+var uuidRe = regexp.MustCompile(`\A` + namedConstraints["uuid"] + `\z`)
+
+// ParamUUID returns the value of the named URL parameter, which must be a UUID
+// in its canonical textual form (e.g., "f81d4fae-7dec-11d0-a765-00a0c91e6bf6"),
+// in lower case.
+func ParamUUID(ctx context.Context, name string) (string, error) {
+	v, err := param(ctx, name, "UUID")
+	if err != nil {
+		return "", err
+	}
+	if !uuidRe.MatchString(v) {
+		return "", &ParamError{Name: name, Value: v, Type: "UUID",
+			Err: errors.New("not a UUID")}
+	}
+	return strings.ToLower(v), nil
+}
+
+// ParamTime returns the value of the named URL parameter as a time, parsed with
+// the given layout (see time.Parse).
+func ParamTime(ctx context.Context, name, layout string) (time.Time, error) {
+	v, err := param(ctx, name, "time")
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(layout, v)
+	if err != nil {
+		return time.Time{}, &ParamError{Name: name, Value: v, Type: "time",
+			Err: err}
+	}
+	return t, nil
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// boundField describes a field of a struct passed to BindParams.
+type boundField struct {
+	index int
+	name  string
+	typ   string
+	set   func(v reflect.Value, s string) error
+}
+
+// The fields of the types passed to BindParams, which are only checked once.
+var boundFields = struct {
+	sync.RWMutex
+	m map[reflect.Type][]boundField
+}{m: make(map[reflect.Type][]boundField)}
+
+/*
+BindParams stores the URL parameters of the request in the fields of the struct
+dst points to. Only fields with a "param" tag are considered, and the tag gives
+the name of the parameter the field is bound to:
+
+	var p struct {
+		User string    `param:"user"`
+		ID   int64     `param:"id"`
+		Day  time.Time `param:"day" layout:"2006-01-02"`
+	}
+	if err := web.BindParams(c, &p); err != nil {
+		return err
+	}
+
+Fields may be strings, booleans, integers, floating-point numbers, time.Time
+(parsed with the layout given in a "layout" tag, or time.RFC3339 by default), or
+of any type implementing encoding.TextUnmarshaler. Fields whose parameter is
+missing are left untouched, which makes it easy to provide defaults for
+parameters in optional segments.
+
+BindParams returns a *ParamError for the first parameter which cannot be
+converted, which handlers returning an error answer with a 400 (see
+ErrorHandlerFunc). It panics if dst is not a pointer to a struct or if it has
+tagged fields of unsupported types, whether or not the request has values for
+them.
+*/
+func BindParams(ctx context.Context, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		log.Panicf("web: BindParams requires a pointer to a struct, "+
+			"not a %T", dst)
+	}
+	v = v.Elem()
+
+	for _, f := range fieldsOf(v.Type()) {
+		s, ok := URLParam(ctx, f.name)
+		if !ok {
+			continue
+		}
+		if err := f.set(v.Field(f.index), s); err != nil {
+			return &ParamError{Name: f.name, Value: s, Type: f.typ,
+				Err: err}
+		}
+	}
+	return nil
+}
+
+// fieldsOf returns the tagged fields of the given struct type, and panics if
+// any of them cannot be bound.
+func fieldsOf(t reflect.Type) []boundField {
+	boundFields.RLock()
+	fields, ok := boundFields.m[t]
+	boundFields.RUnlock()
+	if ok {
+		return fields
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("param")
+		if name == "" {
+			continue
+		}
+		if f.PkgPath != "" {
+			log.Panicf("web: BindParams cannot bind unexported field %s",
+				f.Name)
+		}
+		fields = append(fields, boundField{
+			index: i,
+			name:  name,
+			typ:   f.Type.String(),
+			set:   setterFor(f),
+		})
+	}
+
+	boundFields.Lock()
+	boundFields.m[t] = fields
+	boundFields.Unlock()
+	return fields
+}
+
+// setterFor returns a function which converts a parameter to the type of the
+// given field and stores it in the field.
+func setterFor(f reflect.StructField) func(v reflect.Value, s string) error {
+	// time.Time is a TextUnmarshaler too, but only of RFC 3339 times.
+	if f.Type == timeType {
+		layout := f.Tag.Get("layout")
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		return func(v reflect.Value, s string) error {
+			t, err := time.Parse(layout, s)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(t))
+			return nil
+		}
+	}
+	if reflect.PtrTo(f.Type).Implements(textUnmarshalerType) {
+		return func(v reflect.Value, s string) error {
+			return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		}
+	}
+
+	switch f.Type.Kind() {
+	case reflect.String:
+		return func(v reflect.Value, s string) error {
+			v.SetString(s)
+			return nil
+		}
+	case reflect.Bool:
+		return func(v reflect.Value, s string) error {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return err.(*strconv.NumError).Err
+			}
+			v.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits := f.Type.Bits()
+		return func(v reflect.Value, s string) error {
+			i, err := strconv.ParseInt(s, 10, bits)
+			if err != nil {
+				return err.(*strconv.NumError).Err
+			}
+			v.SetInt(i)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		bits := f.Type.Bits()
+		return func(v reflect.Value, s string) error {
+			u, err := strconv.ParseUint(s, 10, bits)
+			if err != nil {
+				return err.(*strconv.NumError).Err
+			}
+			v.SetUint(u)
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		bits := f.Type.Bits()
+		return func(v reflect.Value, s string) error {
+			x, err := strconv.ParseFloat(s, bits)
+			if err != nil {
+				return err.(*strconv.NumError).Err
+			}
+			v.SetFloat(x)
+			return nil
+		}
+	}
+	log.Panicf("web: BindParams cannot bind field %s of type %v",
+		f.Name, f.Type)
+	panic("log.Panicf does not return")
+}
@@ -0,0 +1,169 @@
+package web
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.net/context"
+)
+
+func paramsContext(params map[string]string) context.Context {
+	return context.WithValue(context.Background(), paramKey, params)
+}
+
+func TestParamInt(t *testing.T) {
+	t.Parallel()
+	c := paramsContext(map[string]string{"id": "42", "name": "carl"})
+
+	if i, err := ParamInt(c, "id"); err != nil || i != 42 {
+		t.Errorf("Expected 42, got %d (%v)", i, err)
+	}
+	_, err := ParamInt(c, "name")
+	if pe, ok := err.(*ParamError); !ok || pe.Name != "name" ||
+		pe.Value != "carl" || pe.StatusCode() != 400 {
+		t.Errorf("Expected a ParamError for name, got %#v", err)
+	}
+	_, err = ParamInt(c, "missing")
+	if pe, ok := err.(*ParamError); !ok || pe.Err != ErrMissingParam {
+		t.Errorf("Expected a missing ParamError, got %#v", err)
+	}
+}
+
+func TestParamUUID(t *testing.T) {
+	t.Parallel()
+	c := paramsContext(map[string]string{
+		"id":  "F81D4FAE-7DEC-11D0-A765-00A0C91E6BF6",
+		"bad": "f81d4fae7dec11d0a76500a0c91e6bf6",
+	})
+
+	u, err := ParamUUID(c, "id")
+	if err != nil || u != "f81d4fae-7dec-11d0-a765-00a0c91e6bf6" {
+		t.Errorf("Unexpected UUID %q (%v)", u, err)
+	}
+	if _, err := ParamUUID(c, "bad"); err == nil {
+		t.Errorf("Expected an error for a malformed UUID")
+	}
+}
+
+func TestParamTime(t *testing.T) {
+	t.Parallel()
+	c := paramsContext(map[string]string{"day": "2014-10-18"})
+
+	d, err := ParamTime(c, "day", "2006-01-02")
+	if err != nil || !d.Equal(time.Date(2014, 10, 18, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected time %v (%v)", d, err)
+	}
+	if _, err := ParamTime(c, "day", time.RFC3339); err == nil {
+		t.Errorf("Expected an error for a mismatched layout")
+	}
+}
+
+type bindTarget struct {
+	User    string    `param:"user"`
+	ID      int64     `param:"id"`
+	Page    uint8     `param:"page"`
+	Ratio   float64   `param:"ratio"`
+	Draft   bool      `param:"draft"`
+	Day     time.Time `param:"day" layout:"2006-01-02"`
+	IP      net.IP    `param:"ip"`
+	Ignored string
+}
+
+func TestBindParams(t *testing.T) {
+	t.Parallel()
+	c := paramsContext(map[string]string{
+		"user":    "carl",
+		"id":      "-12",
+		"ratio":   "0.5",
+		"draft":   "true",
+		"day":     "2014-10-18",
+		"ip":      "127.0.0.1",
+		"Ignored": "x",
+	})
+
+	dst := bindTarget{Page: 1}
+	if err := BindParams(c, &dst); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if dst.User != "carl" || dst.ID != -12 || dst.Page != 1 ||
+		dst.Ratio != 0.5 || !dst.Draft || dst.Day.Day() != 18 ||
+		!dst.IP.Equal(net.IPv4(127, 0, 0, 1)) || dst.Ignored != "" {
+		t.Errorf("Unexpected result %+v", dst)
+	}
+
+	c = paramsContext(map[string]string{"page": "256"})
+	err := BindParams(c, &dst)
+	if pe, ok := err.(*ParamError); !ok || pe.Name != "page" ||
+		pe.Type != "uint8" {
+		t.Errorf("Expected a ParamError for page, got %v", err)
+	}
+}
+
+func TestBindParamsPanics(t *testing.T) {
+	t.Parallel()
+	c := paramsContext(map[string]string{"a": "1"})
+
+	for _, dst := range []interface{}{
+		bindTarget{},
+		new(int),
+		&struct {
+			A []int `param:"a"`
+		}{},
+		&struct {
+			a int `param:"a"`
+		}{},
+		// Even if the request has no value for the field
+		&struct {
+			B map[string]int `param:"b"`
+		}{},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a panic for %T", dst)
+				}
+			}()
+			BindParams(c, dst)
+		}()
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	t.Parallel()
+	m := New()
+	m.Get("/users/:id", func(c context.Context, w http.ResponseWriter, r *http.Request) error {
+		var p struct {
+			ID int `param:"id"`
+		}
+		if err := BindParams(c, &p); err != nil {
+			return err
+		}
+		if p.ID == 0 {
+			return errors.New("secret details")
+		}
+		w.Write([]byte("ok"))
+		return nil
+	})
+
+	for _, test := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/users/1", 200, "ok"},
+		{"/users/carl", 400, `web: invalid int "carl" for URL parameter "id": invalid syntax` + "\n"},
+		{"/users/0", 500, "Internal Server Error\n"},
+	} {
+		r, _ := http.NewRequest("GET", test.path, nil)
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		if w.Code != test.code || w.Body.String() != test.body {
+			t.Errorf("Expected %d %q for %s, got %d %q", test.code,
+				test.body, test.path, w.Code, w.Body.String())
+		}
+	}
+}
@@ -39,6 +39,12 @@ func WrapWriter(w http.ResponseWriter) WriterProxy {
 	if cn && fl && hj && rf {
 		return &fancyWriter{bw}
 	}
+	if cn && fl {
+		return &notifyFlushWriter{flushWriter{bw}}
+	}
+	if fl {
+		return &flushWriter{bw}
+	}
 	return &bw
 }
 
@@ -119,6 +125,34 @@ func (f *fancyWriter) ReadFrom(r io.Reader) (int64, error) {
 	return rf.ReadFrom(r)
 }
 
+// flushWriter is a writer that additionally satisfies http.Flusher, for
+// http.ResponseWriters which can be flushed but lack some of the other
+// interfaces of fancyWriter, such as those of HTTP/2 connections.
+type flushWriter struct {
+	basicWriter
+}
+
+func (f *flushWriter) Flush() {
+	fl := f.basicWriter.ResponseWriter.(http.Flusher)
+	fl.Flush()
+}
+
+// notifyFlushWriter is a flushWriter that additionally satisfies
+// http.CloseNotifier, as the http.ResponseWriters of HTTP/2 connections do.
+type notifyFlushWriter struct {
+	flushWriter
+}
+
+func (f *notifyFlushWriter) CloseNotify() <-chan bool {
+	cn := f.basicWriter.ResponseWriter.(http.CloseNotifier)
+	return cn.CloseNotify()
+}
+
+var _ http.Flusher = &flushWriter{}
+
+var _ http.CloseNotifier = &notifyFlushWriter{}
+var _ http.Flusher = &notifyFlushWriter{}
+
 var _ http.CloseNotifier = &fancyWriter{}
 var _ http.Flusher = &fancyWriter{}
 var _ http.Hijacker = &fancyWriter{}
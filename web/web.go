@@ -99,3 +99,40 @@ type HandlerFunc func(context.Context, http.ResponseWriter, *http.Request)
 func (h HandlerFunc) ServeHTTPC(c context.Context, w http.ResponseWriter, r *http.Request) {
 	h(c, w, r)
 }
+
+// OptionsHandlerFunc is a HandlerFunc for NotFound handlers which answer OPTIONS
+// requests for every path that has routes, such as middleware.AutomaticOptions.
+// When the NotFound handler of a Mux is an OptionsHandlerFunc, the "Allow"
+// header of its 405 responses (see Mux.MethodNotAllowed) includes OPTIONS.
+type OptionsHandlerFunc func(context.Context, http.ResponseWriter, *http.Request)
+
+// ServeHTTPC wraps ServeHTTP with a context parameter.
+func (h OptionsHandlerFunc) ServeHTTPC(c context.Context, w http.ResponseWriter, r *http.Request) {
+	h(c, w, r)
+}
+
+// ErrorHandlerFunc is a HandlerFunc which may fail. If it returns an error, the
+// error is answered with its status code if it has a StatusCode method (as
+// ParamError does, which turns malformed URL parameters into 400 responses), and
+// with a 500 (Internal Server Error) otherwise. In the former case, the error's
+// message is sent to the client.
+//
+// Functions with the signature of ErrorHandlerFunc may be given to the
+// route-adding functions of Mux as they are.
+type ErrorHandlerFunc func(context.Context, http.ResponseWriter, *http.Request) error
+
+// ServeHTTPC wraps ServeHTTP with a context parameter.
+func (h ErrorHandlerFunc) ServeHTTPC(c context.Context, w http.ResponseWriter, r *http.Request) {
+	err := h(c, w, r)
+	if err == nil {
+		return
+	}
+	if sc, ok := err.(interface {
+		StatusCode() int
+	}); ok {
+		http.Error(w, err.Error(), sc.StatusCode())
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError),
+		http.StatusInternalServerError)
+}